@@ -0,0 +1,124 @@
+package algos
+
+import (
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/grafov/m3u8"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adBreakTracker finds the ad breaks in a live stream, from the segments of its media playlist
+// Each segment should be added once and in order, consecutive ad segments are one break
+type adBreakTracker struct {
+	mutex   sync.Mutex
+	inBreak bool
+	breaks  []models.AdBreak
+}
+
+// adSegmentSource returns how we know the segment is an ad, or empty if it is not one
+// Stitched ads are marked with a date range, older ones only had "Amazon" in the segment title
+func adSegmentSource(segment *m3u8.MediaSegment, dateRanges []twitch.LiveDateRange) (string, string) {
+	if !segment.ProgramDateTime.IsZero() {
+		for _, dateRange := range dateRanges {
+			if dateRange.IsStitchedAd() && dateRange.Contains(segment.ProgramDateTime) {
+				return "daterange", dateRange.Id
+			}
+		}
+	}
+	if strings.Contains(strings.ToLower(segment.Title), "amazon") {
+		return "title", ""
+	}
+	return "", ""
+}
+
+// adBreakAhead returns true if a stitched ad date range is still open after the segment, so the next segments could be ads
+func adBreakAhead(segment *m3u8.MediaSegment, dateRanges []twitch.LiveDateRange) bool {
+	if segment.ProgramDateTime.IsZero() {
+		return false
+	}
+	end := segment.ProgramDateTime.Add(time.Duration(segment.Duration * float64(time.Second)))
+	for _, dateRange := range dateRanges {
+		if dateRange.IsStitchedAd() && (dateRange.Contains(end) || dateRange.StartDate.After(end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add checks if the segment is an ad, and adds it to the current ad break if so
+func (tracker *adBreakTracker) Add(segment *m3u8.MediaSegment, dateRanges []twitch.LiveDateRange) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	source, id := adSegmentSource(segment, dateRanges)
+	if source == "" {
+		tracker.inBreak = false
+		return false
+	}
+	if !tracker.inBreak {
+		start := segment.ProgramDateTime
+		if start.IsZero() {
+			start = time.Now()
+		}
+		tracker.breaks = append(tracker.breaks, models.AdBreak{Id: id, Source: source, Start: start})
+		tracker.inBreak = true
+	}
+	adBreak := &tracker.breaks[len(tracker.breaks)-1]
+	adBreak.DurationSeconds += segment.Duration
+	if adBreak.Id == "" && id != "" {
+		adBreak.Id = id
+		adBreak.Source = source
+	}
+	return true
+}
+
+// InBreak returns true if the last segment added was an ad
+func (tracker *adBreakTracker) InBreak() bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.inBreak
+}
+
+// Breaks returns the ad breaks after the reference time (the first frame of the recording)
+func (tracker *adBreakTracker) Breaks(reference time.Time, cut bool) []models.AdBreak {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	breaks := append([]models.AdBreak(nil), tracker.breaks...)
+	for i := range breaks {
+		breaks[i].Cut = cut
+	}
+	return adBreaksFrom(breaks, reference)
+}
+
+// adBreaksFrom sets the offset of each ad break from the reference time, dropping those which ended before it
+// A break which was already going at the reference time is trimmed to start at it
+func adBreaksFrom(breaks []models.AdBreak, reference time.Time) []models.AdBreak {
+	var valid []models.AdBreak
+	for _, adBreak := range breaks {
+		adBreak.OffsetSeconds = adBreak.Start.Sub(reference).Seconds()
+		if adBreak.OffsetSeconds+adBreak.DurationSeconds <= 0 {
+			continue
+		}
+		if adBreak.OffsetSeconds < 0 {
+			adBreak.DurationSeconds += adBreak.OffsetSeconds
+			adBreak.OffsetSeconds = 0
+		}
+		valid = append(valid, adBreak)
+	}
+	return valid
+}
+
+// adCutOffset moves an offset in the full recording to where it is once the cut ad breaks are removed
+// Anything during an ad break ends up at the point the break was cut out
+func adCutOffset(breaks []models.AdBreak, offset float64) float64 {
+	removed := 0.0
+	for _, adBreak := range breaks {
+		if !adBreak.Cut || adBreak.OffsetSeconds >= offset {
+			continue
+		}
+		removed += math.Min(adBreak.DurationSeconds, offset-adBreak.OffsetSeconds)
+	}
+	return offset - removed
+}
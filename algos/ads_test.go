@@ -0,0 +1,87 @@
+package algos
+
+import (
+	"github.com/goldbattle/twitch_vods/models"
+	"testing"
+	"time"
+)
+
+func TestAdCutOffset(t *testing.T) {
+	breaks := []models.AdBreak{
+		{OffsetSeconds: 100, DurationSeconds: 30, Cut: true},
+		{OffsetSeconds: 200, DurationSeconds: 60, Cut: false},
+		{OffsetSeconds: 300, DurationSeconds: 15, Cut: true},
+	}
+	tests := []struct {
+		name   string
+		offset float64
+		want   float64
+	}{
+		{"before the first break", 50, 50},
+		{"at the start of a break", 100, 100},
+		{"during a break", 110, 100},
+		{"at the end of a break", 130, 100},
+		{"after a break", 150, 120},
+		{"break which was not cut", 250, 220},
+		{"during a later break", 305, 270},
+		{"after every break", 400, 355},
+	}
+	for _, test := range tests {
+		if got := adCutOffset(breaks, test.offset); got != test.want {
+			t.Errorf("%s: adCutOffset(%v) = %v, want %v", test.name, test.offset, got, test.want)
+		}
+	}
+	if got := adCutOffset(nil, 50); got != 50 {
+		t.Errorf("got %v without any breaks", got)
+	}
+}
+
+func TestAdBreaksFrom(t *testing.T) {
+	reference := time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+	breaks := adBreaksFrom([]models.AdBreak{
+		{Id: "before", Start: reference.Add(-60 * time.Second), DurationSeconds: 30},
+		{Id: "ending", Start: reference.Add(-30 * time.Second), DurationSeconds: 30},
+		{Id: "going", Start: reference.Add(-10 * time.Second), DurationSeconds: 30, Cut: true},
+		{Id: "after", Start: reference.Add(60 * time.Second), DurationSeconds: 30},
+	}, reference)
+
+	// Breaks which were over at the reference are dropped, and one which was going is trimmed
+	if len(breaks) != 2 || breaks[0].Id != "going" || breaks[1].Id != "after" {
+		t.Fatalf("got breaks %v", breaks)
+	}
+	if breaks[0].OffsetSeconds != 0 || breaks[0].DurationSeconds != 20 || !breaks[0].Cut {
+		t.Errorf("got %v for the break going at the reference", breaks[0])
+	}
+	if breaks[1].OffsetSeconds != 60 || breaks[1].DurationSeconds != 30 {
+		t.Errorf("got %v for the break after the reference", breaks[1])
+	}
+}
+
+func TestStreamlinkAdEvents(t *testing.T) {
+	output := &streamlinkOutput{}
+	start := time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+	event := func(line string, seconds int) bool {
+		parsed, ok := parseStreamlinkLine(line)
+		if !ok {
+			t.Fatalf("%q is not an event", line)
+		}
+		return output.addAdEvent(parsed, start.Add(time.Duration(seconds)*time.Second))
+	}
+
+	// A break streamlink only detected is still in the video
+	event("[plugins.twitch][info] Detected advertisement break of 30 seconds", 0)
+	if len(output.adBreaks) != 1 || output.adBreaks[0].Cut || output.adBreaks[0].DurationSeconds != 30 {
+		t.Fatalf("got %v for a detected break", output.adBreaks)
+	}
+
+	// Once it filters out the break, it is cut for as long as the output was held back
+	event("[plugins.twitch][info] Detected advertisement break of 30 seconds", 100)
+	event("[stream.hls][info] Filtering out segments and pausing stream output", 101)
+	event("[stream.hls][info] Resuming stream output", 125)
+	if len(output.adBreaks) != 2 || !output.adBreaks[1].Cut || output.adBreaks[1].DurationSeconds != 24 {
+		t.Errorf("got %v for a filtered break", output.adBreaks)
+	}
+	if output.paused || event("[stream.hls][info] Resuming stream output", 130) {
+		t.Errorf("a second resume should not change the breaks")
+	}
+}
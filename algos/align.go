@@ -0,0 +1,99 @@
+package algos
+
+import (
+	"errors"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/grafov/m3u8"
+	"github.com/nicklaw5/helix"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamlinkLiveEdge is how many segments from the end of the live playlist streamlink starts at
+func streamlinkLiveEdge(options []string) int {
+	for i, option := range options {
+		value := ""
+		if strings.HasPrefix(option, "--hls-live-edge=") {
+			value = strings.TrimPrefix(option, "--hls-live-edge=")
+		} else if option == "--hls-live-edge" && i+1 < len(options) {
+			value = options[i+1]
+		}
+		if edge, err := strconv.Atoi(value); err == nil && edge > 0 {
+			return edge
+		}
+	}
+	return 3
+}
+
+// firstSegmentTime returns the EXT-X-PROGRAM-DATE-TIME of the segment a recording starting now would begin at
+func firstSegmentTime(username string, liveEdge int) (time.Time, error) {
+
+	// All variants are cut at the same times, so we just use the first one with video
+	master, err := twitch.GetLiveMasterPlaylist(username)
+	if err != nil {
+		return time.Time{}, err
+	}
+	uri := master.Variants[0].URI
+	for _, variant := range master.Variants {
+		if !isAudioOnly(variant) {
+			uri = variant.URI
+			break
+		}
+	}
+	playlist, err := twitch.GetLiveMediaPlaylist(uri)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Count back from the newest segment
+	var segments []*m3u8.MediaSegment
+	for _, segment := range playlist.Segments {
+		if segment != nil {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) < 1 {
+		return time.Time{}, errors.New("no segments in live playlist")
+	}
+	idx := len(segments) - liveEdge
+	if idx < 0 {
+		idx = 0
+	}
+	if segments[idx].ProgramDateTime.IsZero() {
+		return time.Time{}, errors.New("live playlist has no program date time")
+	}
+	return segments[idx].ProgramDateTime, nil
+
+}
+
+// getChatAlignment finds the time of the first frame of a recording whose file was just created
+// We use the program date time of the first segment streamlink would have recorded, else when the file was created
+// NOTE: we fetch the playlist ourselves after streamlink has, so this is only an estimate of the segment it started at
+func getChatAlignment(username string, stream helix.Stream, fileCreatedAt time.Time, liveEdge int) models.ChatAlignment {
+	tm, _ := firstSegmentTime(username, liveEdge)
+	alignment := chatAlignment(stream, fileCreatedAt, tm)
+	if alignment.Reference == "program_date_time" {
+		alignment.Reference = "estimated_program_date_time"
+	}
+	return alignment
+}
+
+// chatAlignment uses the program date time of the first segment if we have one, else when the file was created
+func chatAlignment(stream helix.Stream, fileCreatedAt time.Time, firstSegment time.Time) models.ChatAlignment {
+	alignment := models.ChatAlignment{}
+	alignment.Reference = "file_created"
+	alignment.ReferenceTime = fileCreatedAt
+	alignment.FileCreatedAt = fileCreatedAt
+	alignment.StreamStartedAt = stream.StartedAt
+	if !firstSegment.IsZero() {
+		alignment.Reference = "program_date_time"
+		alignment.ReferenceTime = firstSegment
+	}
+	alignment.SkewSeconds = fileCreatedAt.Sub(alignment.ReferenceTime).Seconds()
+	if !stream.StartedAt.IsZero() {
+		alignment.StreamOffsetSeconds = alignment.ReferenceTime.Sub(stream.StartedAt).Seconds()
+	}
+	return alignment
+}
@@ -0,0 +1,77 @@
+package algos
+
+import (
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/nicklaw5/helix"
+	"log"
+	"time"
+)
+
+// getBackfillVods returns every vod of the channel which matches the backfill filters, oldest first
+func getBackfillVods(client *helix.Client, usernameId string, config models.ConfigurationFile) ([]helix.Video, error) {
+
+	// Parse our date range (empty is unbounded)
+	after, before := time.Time{}, time.Time{}
+	if config.BackfillAfter != "" {
+		tm, err := time.Parse(time.RFC3339, config.BackfillAfter)
+		if err != nil {
+			return nil, err
+		}
+		after = tm
+	}
+	if config.BackfillBefore != "" {
+		tm, err := time.Parse(time.RFC3339, config.BackfillBefore)
+		if err != nil {
+			return nil, err
+		}
+		before = tm
+	}
+
+	// Get all vods and reverse them so we go oldest to newest
+	vods, err := twitch.GetAllVods(client, usernameId, config.BackfillType, after, before)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(vods)-1; i < j; i, j = i+1, j-1 {
+		vods[i], vods[j] = vods[j], vods[i]
+	}
+	return vods, nil
+
+}
+
+func DownloadVodBackfill(client *helix.Client, username string, usernameId string, config models.ConfigurationFile) {
+
+	// Get all our VODs
+	vods, err := getBackfillVods(client, usernameId, config)
+	if err != nil {
+		log.Printf("VIDEO: %s - backfill error %s\n", username, err)
+		return
+	}
+	log.Printf("VIDEO: %s - backfilling %d vods\n", username, len(vods))
+
+	// For each vod lets download it (completed ones will be skipped)
+	for ct, vod := range vods {
+		log.Printf("VIDEO: %s - vod id %s backfilling (%d/%d)\n", username, vod.ID, ct+1, len(vods))
+		DownloadVod(client, username, usernameId, config, vod)
+	}
+
+}
+
+func DownloadChatBackfill(client *helix.Client, username string, usernameId string, config models.ConfigurationFile) {
+
+	// Get all our VODs
+	vods, err := getBackfillVods(client, usernameId, config)
+	if err != nil {
+		log.Printf("CHAT: %s - backfill error %s\n", username, err)
+		return
+	}
+	log.Printf("CHAT: %s - backfilling %d vods\n", username, len(vods))
+
+	// For each vod lets download it (completed ones will be skipped)
+	for ct, vod := range vods {
+		log.Printf("CHAT: %s - vod id %s backfilling (%d/%d)\n", username, vod.ID, ct+1, len(vods))
+		DownloadChat(client, username, usernameId, config, vod)
+	}
+
+}
@@ -0,0 +1,74 @@
+package algos
+
+import (
+	"encoding/base64"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/nicklaw5/helix"
+	"log"
+)
+
+// EmbedBadges downloads the image of every badge (set id + version) used in the comments
+// Both the global and the channel badge sets are resolved, so sub and bit badges of the streamer are correct
+// Badges which are already embedded in existing are kept as-is, since the streamer might change them later
+func EmbedBadges(client *helix.Client, username string, channelId string, comments helpers.CommentIterator, existing []models.TwitchBadge) []models.TwitchBadge {
+
+	// Start from what we have already embedded
+	badges := make([]models.TwitchBadge, 0)
+	badgeIndex := make(map[string]int)
+	for _, badge := range existing {
+		badgeIndex[badge.Name] = len(badges)
+		badges = append(badges, badge)
+	}
+
+	// Find all the badges used in chat that we do not have
+	missing := make(map[string]map[string]bool)
+	comments(func(comment models.Comments) {
+		for _, badge := range comment.Message.UserBadges {
+			if idx, ok := badgeIndex[badge.Id]; ok {
+				if _, ok := badges[idx].Versions[badge.Version]; ok {
+					continue
+				}
+			}
+			if _, ok := missing[badge.Id]; !ok {
+				missing[badge.Id] = make(map[string]bool)
+			}
+			missing[badge.Id][badge.Version] = true
+		}
+	})
+	if len(missing) < 1 {
+		return badges
+	}
+
+	// Get the badges sets of this channel
+	sets, err := twitch.GetChatBadges(client, channelId)
+	if err != nil {
+		log.Printf("BADGES: %s - unable to get badges: %s\n", username, err)
+		return badges
+	}
+
+	// Download the images of the ones we need
+	for name, versions := range missing {
+		for version := range versions {
+			badgeVersion, ok := sets[name][version]
+			if !ok {
+				log.Printf("BADGES: %s - unknown badge %s/%s\n", username, name, version)
+				continue
+			}
+			data, err := twitch.DownloadImage(badgeVersion.ImageUrl2x)
+			if err != nil {
+				log.Printf("BADGES: %s - unable to get badge %s/%s: %s\n", username, name, version, err)
+				continue
+			}
+			if _, ok := badgeIndex[name]; !ok {
+				badgeIndex[name] = len(badges)
+				badges = append(badges, models.TwitchBadge{Name: name, Versions: make(map[string]string)})
+			}
+			badges[badgeIndex[name]].Versions[version] = base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	log.Printf("BADGES: %s - embedded %d badge sets\n", username, len(badges))
+	return badges
+
+}
@@ -1,15 +1,19 @@
 package algos
 
 import (
-	"encoding/json"
+	"errors"
 	"github.com/goldbattle/twitch_vods/helpers"
 	"github.com/goldbattle/twitch_vods/models"
 	"github.com/goldbattle/twitch_vods/twitch"
 	"github.com/nicklaw5/helix"
-	"io/ioutil"
 	"log"
-	"net/http"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func DownloadChatLatest(client *helix.Client, username string, usernameId string, config models.ConfigurationFile) {
@@ -44,62 +48,209 @@ func DownloadChat(client *helix.Client, username string, usernameId string, conf
 		return
 	}
 
+	// All comments are appended to a journal on disk as they come in, so we never hold the whole chat in memory
+	// NOTE: older downloads only have the chat json, so we seed the journal from that the first time
+	journalPath := helpers.GetChatJournalPath(config.SaveDirectory, username, vod)
+	saveFile := strings.TrimSuffix(journalPath, ".jsonl") + ".json"
+	if _, err := os.Stat(journalPath); os.IsNotExist(err) {
+		existing, err := helpers.LoadChatFromFile(config.SaveDirectory, username, usernameId, vod)
+		if err == nil && len(existing.Comments) > 0 {
+			journal, err := helpers.OpenChatJournal(journalPath)
+			if err != nil {
+				log.Printf("CHAT: %s - error %s\n", username, err)
+				return
+			}
+			err = journal.Append(existing.Comments...)
+			_ = journal.Close()
+			if err != nil {
+				log.Printf("CHAT: %s - error %s\n", username, err)
+				return
+			}
+		}
+	}
+
+	// Load what we have already downloaded so we only need to request the newest messages
+	// NOTE: we start at the last offset we have, so there will be some overlap which is removed below
+	// NOTE: live only messages merged from a live recording are not vod messages, so the vod copy replaces them if it comes
+	startOffset := 0.0
+	existingIds := make(map[string]bool)
+	err := helpers.ScanChatJournal(journalPath, func(comment models.Comments) {
+		if comment.LiveOnly {
+			return
+		}
+		existingIds[comment.Id] = true
+		startOffset = math.Max(startOffset, comment.ContentOffsetSeconds)
+	})
+	if err == nil {
+		log.Printf("CHAT: %s - vod %s, resuming from %d comments (offset %.0f sec)\n", username, vod.ID, len(existingIds), startOffset)
+	}
+	journal, err := helpers.OpenChatJournal(journalPath)
+	if err != nil {
+		log.Printf("CHAT: %s - error %s\n", username, err)
+		return
+	}
+
 	// Now can start downloading the chat
+	// NOTE: the first page is requested by offset, then we follow the cursor of the last comment
 	currentCursor := ""
 	isStart := true
 	hasError := false
-	var comments []models.Comments
+	countNew := 0
 	for currentCursor != "" || isStart {
 
 		// Call our api endpoint
-		baseUrl := "https://api.twitch.tv/v5/videos/" + vod.ID + "/comments"
-		if currentCursor != "" {
-			baseUrl += "?cursor=" + currentCursor
-		} else {
-			baseUrl += "?content_offset_seconds=0"
+		err := errors.New("startup")
+		apiResponse := models.GraphQLVideoCommentsResponse{}
+		for i := 1; i < 5; i++ {
+			apiResponse, err = twitch.GetVideoComments(vod.ID, startOffset, currentCursor)
+			if err == nil {
+				break
+			}
+			log.Printf("CHAT: %s - error %s (try %d)\n", username, err, i)
+			time.Sleep(time.Duration(i) * time.Second)
 		}
-		clientComments := &http.Client{}
-		req, err := http.NewRequest("GET", baseUrl, nil)
 		if err != nil {
-			log.Printf("CHAT: %s - error %s\n", username, err)
 			hasError = true
 			break
 		}
-		req.Header.Set("Accept", "application/vnd.twitchtv.twitch+json; charset=UTF-8")
-		req.Header.Set("Client-Id", "kimne78kx3ncx6brgo4mv6wki5h1ko")
-		res, err := clientComments.Do(req)
-		if err != nil {
-			log.Printf("CHAT: %s - error %s\n", username, err)
-			hasError = true
-			break
+
+		// Move forward in time
+		var comments []models.Comments
+		edges := apiResponse.Data.Video.Comments.Edges
+		for _, edge := range edges {
+			if existingIds[edge.Node.ID] {
+				continue
+			}
+			existingIds[edge.Node.ID] = true
+			comments = append(comments, convertGraphQLComment(edge.Node, vod))
 		}
-		defer res.Body.Close()
-		body, err := ioutil.ReadAll(res.Body)
+		err = journal.Append(comments...)
 		if err != nil {
 			log.Printf("CHAT: %s - error %s\n", username, err)
 			hasError = true
 			break
 		}
+		countNew += len(comments)
+		currentCursor = ""
+		if apiResponse.Data.Video.Comments.PageInfo.HasNextPage && len(edges) > 0 {
+			currentCursor = edges[len(edges)-1].Cursor
+		}
+		isStart = false
+
+	}
+	_ = journal.Close()
 
-		// Convert to the api response
-		apiResponse := models.CommentsV5ApiResponse{}
-		err = json.Unmarshal(body, &apiResponse)
+	// Only re-write the file if we have new chat messages
+	// NOTE: on an error what we got is still in the journal, so the next run will continue from there
+	if hasError {
+		return
+	}
+	_, errStat := os.Stat(saveFile)
+	if countNew < 1 && errStat == nil {
+		log.Printf("CHAT: %s - vod %s, no new messages\n", username, vod.ID)
+	} else {
+		log.Printf("CHAT: %s - vod %s, saving %d new messages\n", username, vod.ID, countNew)
+		emotes, _ := helpers.LoadChatEmotes(saveFile)
+		if config.EmbedEmotes {
+			emotes = EmbedEmotes(username, usernameId, helpers.IterateChatJournal(journalPath), emotes, config)
+		}
+		if config.EmbedBadges {
+			emotes.TwitchBadges = EmbedBadges(client, username, usernameId, helpers.IterateChatJournal(journalPath), emotes.TwitchBadges)
+		}
+		streamerId, _ := strconv.Atoi(usernameId)
+		_, err = helpers.CompactChatJournal(journalPath, saveFile, models.Streamer{Name: username, ID: streamerId}, 0, emotes)
 		if err != nil {
-			log.Printf("CHAT: %s - error api response is bad %s\n", username, err)
-			hasError = true
-			break
+			log.Printf("CHAT: %s - error %s\n", username, err)
+			return
 		}
+	}
 
-		// Move forward in time
-		comments = append(comments, apiResponse.Comments...)
-		currentCursor = apiResponse.Next
-		isStart = false
+	// Merge in any live recording we have of this vod
+	// NOTE: we wait till the vod will not change, else messages we have not downloaded yet would be flagged live only
+	if config.MergeLiveChat && int(diff.Minutes()) > config.SkipIfOlderMin {
+		MergeLiveChat(username, usernameId, config, vod)
+	}
+
+}
+
+// convertGraphQLComment converts a GQL comment node into the TwitchDownloader comment format
+// https://github.com/lay295/TwitchDownloader/blob/master/TwitchDownloaderCore/TwitchObjects/ChatRoot.cs
+func convertGraphQLComment(node models.GraphQLVideoComment, vod helix.Video) models.Comments {
 
+	// Create the VOD comment!
+	comment := models.Comments{}
+	comment.Id = node.ID
+	comment.CreatedAt = node.CreatedAt
+	comment.UpdatedAt = node.CreatedAt
+	comment.ChannelId = vod.UserID
+	comment.ContentType = "video"
+	comment.ContentId = vod.ID
+	comment.ContentOffsetSeconds = node.ContentOffsetSeconds
+	if node.Commenter != nil {
+		comment.Commenter.DisplayName = node.Commenter.DisplayName
+		comment.Commenter.Id = node.Commenter.ID
+		comment.Commenter.Name = node.Commenter.Login
 	}
+	comment.Commenter.Type = "user"
+	comment.Source = "chat"
+	comment.State = "published"
+	comment.MoreReplies = false
+	comment.Message.UserColor = node.Message.UserColor
 
-	// If we have chat messages then save to file
-	if len(comments) > 0 && !hasError {
-		helpers.SaveChatToFile(config.SaveDirectory, username, usernameId, vod, comments)
+	// Loop through all user badges (sub, mod, etc..)
+	hasBitsBadge := false
+	for _, badge := range node.Message.UserBadges {
+		if badge.SetID == "" {
+			continue
+		}
+		if badge.SetID == "bits" {
+			hasBitsBadge = true
+		}
+		userbadge := models.UserBadge{}
+		userbadge.Id = badge.SetID
+		userbadge.Version = badge.Version
+		comment.Message.UserBadges = append(comment.Message.UserBadges, userbadge)
+	}
+
+	// The GQL message is already split into fragments, so we rebuild the body and emote positions from it
+	position := 0
+	for _, fragment := range node.Message.Fragments {
+		frag := models.Fragment{}
+		frag.Text = fragment.Text
+		length := utf8.RuneCountInString(fragment.Text)
+		if fragment.Emote != nil {
+			frag.Emoticon = &models.EmoticonFragment{}
+			frag.Emoticon.EmoticonId = fragment.Emote.EmoteID
+			tmp := models.Emoticon{}
+			tmp.Id = fragment.Emote.EmoteID
+			tmp.Begin = position
+			tmp.End = position + length - 1
+			comment.Message.Emoticons = append(comment.Message.Emoticons, tmp)
+		}
+		comment.Message.Fragments = append(comment.Message.Fragments, frag)
+		comment.Message.Body += fragment.Text
+		if hasBitsBadge && fragment.Emote == nil {
+			comment.Message.BitsSpent += cheermoteBits(fragment.Text)
+		}
+		position += length
 	}
+	return comment
 
 }
+
+// cheermoteRegex matches the global cheermotes (e.g. Cheer100, Kappa50), which is how bits show up in the vod chat
+// NOTE: the GQL comments do not have the bits of a message, so these are only counted for users with a bits badge
+// NOTE: channel specific cheermotes are not known here, so their bits are not counted
+var cheermoteRegex = regexp.MustCompile(`(?i)^(cheer|doodlecheer|biblethump|cheerwhal|corgo|scoops|uni|showlove|party|seemsgood|pride|kappa|frankerz|heyguys|dansgame|elegiggle|trihard|kreygasm|4head|swiftrage|notlikethis|failfish|vohiyo|pjsalt|mrdestructoid|bday|ripcheer|shamrock|bitboss|streamlabs|muxy|holidaycheer|goal|anon|charity)(\d+)$`)
+
+// cheermoteBits returns how many bits the cheermotes in the text are worth
+func cheermoteBits(text string) int {
+	bits := 0
+	for _, word := range strings.Fields(text) {
+		if match := cheermoteRegex.FindStringSubmatch(word); match != nil {
+			value, _ := strconv.Atoi(match[2])
+			bits += value
+		}
+	}
+	return bits
+}
@@ -0,0 +1,32 @@
+package algos
+
+import (
+	"encoding/json"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/nicklaw5/helix"
+	"testing"
+)
+
+func TestConvertGraphQLCommentBits(t *testing.T) {
+	convert := func(raw string) models.Comments {
+		t.Helper()
+		node := models.GraphQLVideoComment{}
+		if err := json.Unmarshal([]byte(raw), &node); err != nil {
+			t.Fatal(err)
+		}
+		return convertGraphQLComment(node, helix.Video{ID: "1234", UserID: "5678"})
+	}
+
+	// Words which look like cheermotes are not bits unless the user has cheered
+	comment := convert(`{"id":"a","message":{"fragments":[{"text":"party5 goal10 Cheer100"}]}}`)
+	if comment.Message.BitsSpent != 0 {
+		t.Errorf("got %d bits without a bits badge", comment.Message.BitsSpent)
+	}
+	comment = convert(`{"id":"b","message":{"fragments":[{"text":"Cheer100 nice Kappa50"},{"text":"Kappa","emote":{"emoteID":"25"}}],"userBadges":[{"setID":"bits","version":"100"}]}}`)
+	if comment.Message.BitsSpent != 150 {
+		t.Errorf("got %d bits, want 150", comment.Message.BitsSpent)
+	}
+	if comment.Message.Body != "Cheer100 nice Kappa50Kappa" || len(comment.Message.Emoticons) != 1 {
+		t.Errorf("got body %q with emotes %v", comment.Message.Body, comment.Message.Emoticons)
+	}
+}
@@ -0,0 +1,283 @@
+package algos
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/models"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chatHubChannelsPerConnection is how many channels we join on one irc connection before opening another
+const chatHubChannelsPerConnection = 100
+
+// ChatMessageHandler is called for every message of a channel we are subscribed to
+// NOTE: it is called from the irc reader, so it should not block for long
+type ChatMessageHandler func(message twitchirc.Message)
+
+// ChatOutageHandler is called when the connection of a channel we are subscribed to has come back after being down
+type ChatOutageHandler func(outage models.ChatOutage)
+
+// ChatSubscription is a subscriber to the messages of a single channel
+type ChatSubscription struct {
+	mutex         sync.Mutex
+	hub           *ChatHub
+	id            int
+	Channel       string
+	handler       ChatMessageHandler
+	outageHandler ChatOutageHandler
+	subscribedAt  time.Time
+	closed        bool
+}
+
+// chatHubConnection is one irc connection and the channels it has joined
+// NOTE: downSince is zero while we are connected (or have never been)
+type chatHubConnection struct {
+	irc       *twitchirc.Client
+	channels  map[string]bool
+	done      chan struct{}
+	downSince time.Time
+}
+
+// Reconnect backoff, it is reset once we have been connected for a while
+const chatHubBackoffMin = 1 * time.Second
+const chatHubBackoffMax = 2 * time.Minute
+
+// ChatHub owns the irc connections of all recordings, and sends each message to the subscribers of its channel
+// Channels are joined when their first subscriber is added and left when their last one is removed
+type ChatHub struct {
+	mutex       sync.Mutex
+	connections []*chatHubConnection
+	subscribers map[string]map[int]*ChatSubscription
+	nextId      int
+	closed      bool
+}
+
+func NewChatHub() *ChatHub {
+	return &ChatHub{subscribers: make(map[string]map[int]*ChatSubscription)}
+}
+
+// Subscribe calls handler for every PRIVMSG, USERNOTICE, CLEARCHAT, CLEARMSG, ROOMSTATE and NOTICE of the channel
+func (hub *ChatHub) Subscribe(channel string, handler ChatMessageHandler) *ChatSubscription {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	channel = strings.ToLower(channel)
+	subscription := &ChatSubscription{hub: hub, id: hub.nextId, Channel: channel, handler: handler, subscribedAt: time.Now()}
+	hub.nextId++
+	if _, ok := hub.subscribers[channel]; !ok {
+		hub.subscribers[channel] = make(map[int]*ChatSubscription)
+		if !hub.closed {
+			hub.join(channel)
+		}
+	}
+	hub.subscribers[channel][subscription.id] = subscription
+	return subscription
+}
+
+// OnOutage sets the handler which is called for every time our connection to the channel was down
+func (subscription *ChatSubscription) OnOutage(handler ChatOutageHandler) {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+	subscription.outageHandler = handler
+}
+
+// Close stops the subscription, and leaves the channel if no one else is subscribed to it
+// If the connection is down, the outage up till now is sent to the subscriber first
+// NOTE: this waits for the handlers to finish, so they are never called again after this returns
+func (subscription *ChatSubscription) Close() {
+	hub := subscription.hub
+	if downSince := subscription.downSince(); !downSince.IsZero() {
+		subscription.outage(downSince, time.Now())
+	}
+	subscription.mutex.Lock()
+	subscription.closed = true
+	subscription.mutex.Unlock()
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	delete(hub.subscribers[subscription.Channel], subscription.id)
+	if len(hub.subscribers[subscription.Channel]) > 0 {
+		return
+	}
+	delete(hub.subscribers, subscription.Channel)
+	for _, connection := range hub.connections {
+		if connection.channels[subscription.Channel] {
+			connection.irc.Depart(subscription.Channel)
+			delete(connection.channels, subscription.Channel)
+		}
+	}
+}
+
+// downSince is when the connection of the channel went down, or zero if it is connected
+func (subscription *ChatSubscription) downSince() time.Time {
+	hub := subscription.hub
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	downSince := time.Time{}
+	for _, connection := range hub.connections {
+		if connection.channels[subscription.Channel] {
+			downSince = connection.downSince
+		}
+	}
+	return downSince
+}
+
+// Close disconnects all connections, no more messages will be sent to subscribers after this returns
+func (hub *ChatHub) Close() {
+	hub.mutex.Lock()
+	hub.closed = true
+	connections := hub.connections
+	hub.connections = nil
+	hub.mutex.Unlock()
+	for _, connection := range connections {
+		// NOTE: disconnect fails if we are in between reconnects, so keep trying until the connection loop exits
+		for stopped := false; !stopped; {
+			_ = connection.irc.Disconnect()
+			select {
+			case <-connection.done:
+				stopped = true
+			case <-time.After(250 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// join adds the channel to a connection which has room, opening a new connection if needed
+// Must be called while holding the hub mutex
+func (hub *ChatHub) join(channel string) {
+	for _, connection := range hub.connections {
+		if len(connection.channels) < chatHubChannelsPerConnection {
+			connection.channels[channel] = true
+			connection.irc.Join(channel)
+			return
+		}
+	}
+	connection := hub.connect()
+	connection.channels[channel] = true
+	connection.irc.Join(channel)
+}
+
+// connect opens a new irc connection which will reconnect until the hub is closed
+// Must be called while holding the hub mutex
+func (hub *ChatHub) connect() *chatHubConnection {
+	connection := &chatHubConnection{
+		irc:      twitchirc.NewAnonymousClient(),
+		channels: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	connection.irc.OnPrivateMessage(func(message twitchirc.PrivateMessage) {
+		hub.dispatch(message.Channel, &message)
+	})
+	connection.irc.OnUserNoticeMessage(func(message twitchirc.UserNoticeMessage) {
+		hub.dispatch(message.Channel, &message)
+	})
+	connection.irc.OnClearChatMessage(func(message twitchirc.ClearChatMessage) {
+		hub.dispatch(message.Channel, &message)
+	})
+	connection.irc.OnClearMessage(func(message twitchirc.ClearMessage) {
+		hub.dispatch(message.Channel, &message)
+	})
+	connection.irc.OnRoomStateMessage(func(message twitchirc.RoomStateMessage) {
+		hub.dispatch(message.Channel, &message)
+	})
+	connection.irc.OnNoticeMessage(func(message twitchirc.NoticeMessage) {
+		hub.dispatch(message.Channel, &message)
+	})
+	hub.connections = append(hub.connections, connection)
+
+	// Connect, and connect again with a backoff if we are dropped
+	// NOTE: connect will block until we disconnect, and will join all our channels again each time
+	connection.irc.OnConnect(func() {
+		hub.reconnected(connection)
+	})
+	go func() {
+		defer close(connection.done)
+		backoff := chatHubBackoffMin
+		for {
+			connectedAt := time.Now()
+			err := connection.irc.Connect()
+			if hub.isClosed() {
+				return
+			}
+			hub.mutex.Lock()
+			if connection.downSince.IsZero() {
+				connection.downSince = time.Now()
+			}
+			hub.mutex.Unlock()
+			if time.Since(connectedAt) > chatHubBackoffMax {
+				backoff = chatHubBackoffMin
+			}
+			log.Printf("CHAT: irc disconnected (%s), reconnecting in %s\n", err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > chatHubBackoffMax {
+				backoff = chatHubBackoffMax
+			}
+			if hub.isClosed() {
+				return
+			}
+		}
+	}()
+	return connection
+
+}
+
+// reconnected tells the subscribers of all channels on the connection how long it was down for
+func (hub *ChatHub) reconnected(connection *chatHubConnection) {
+	hub.mutex.Lock()
+	downSince := connection.downSince
+	connection.downSince = time.Time{}
+	var subscriptions []*ChatSubscription
+	for channel := range connection.channels {
+		for _, subscription := range hub.subscribers[channel] {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	hub.mutex.Unlock()
+	if downSince.IsZero() {
+		return
+	}
+	log.Printf("CHAT: irc reconnected after %s\n", time.Since(downSince).Round(time.Second))
+	for _, subscription := range subscriptions {
+		subscription.outage(downSince, time.Now())
+	}
+}
+
+// outage sends the outage to the subscriber, only the part after it subscribed
+func (subscription *ChatSubscription) outage(start time.Time, end time.Time) {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+	if subscription.closed || subscription.outageHandler == nil {
+		return
+	}
+	if start.Before(subscription.subscribedAt) {
+		start = subscription.subscribedAt
+	}
+	if !end.After(start) {
+		return
+	}
+	subscription.outageHandler(models.ChatOutage{Start: start, End: end, DurationSeconds: end.Sub(start).Seconds()})
+}
+
+func (hub *ChatHub) isClosed() bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return hub.closed
+}
+
+// dispatch sends the message to everyone subscribed to its channel
+func (hub *ChatHub) dispatch(channel string, message twitchirc.Message) {
+	hub.mutex.Lock()
+	var subscriptions []*ChatSubscription
+	for _, subscription := range hub.subscribers[strings.ToLower(channel)] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	hub.mutex.Unlock()
+	for _, subscription := range subscriptions {
+		subscription.mutex.Lock()
+		if !subscription.closed {
+			subscription.handler(message)
+		}
+		subscription.mutex.Unlock()
+	}
+}
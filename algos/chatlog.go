@@ -0,0 +1,356 @@
+package algos
+
+import (
+	"encoding/json"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/nicklaw5/helix"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chatLogMessage is a message we have seen recently, so it can be added to a stream we only just found out is live
+type chatLogMessage struct {
+	Time    time.Time
+	Message twitchirc.Message
+}
+
+// chatLogStream is the chat json of a single live stream that we are writing
+type chatLogStream struct {
+	Stream         helix.Stream
+	VodId          string
+	PathJournal    string
+	PathJson       string
+	PathModeration string
+	PathInfo       string
+	MetaData       models.StreamMetaData
+	Journal        *helpers.ChatJournal
+	Moderation     *moderationTracker
+	Events         *helpers.ModerationJournal
+}
+
+// chatLogChannel is the state of a single channel we are logging
+type chatLogChannel struct {
+	Username   string
+	UsernameId string
+	LogDay     string
+	LogFile    *os.File
+	Recent     []chatLogMessage
+	Stream     *chatLogStream
+}
+
+// ChatLogger records the chat of many channels at all times using the shared chat hub
+// Each channel has a raw irc log for each day (UTC), and while a channel is live its chat is also written into a chat json
+type ChatLogger struct {
+	mutex    sync.Mutex
+	client   *helix.Client
+	config   models.ConfigurationFile
+	channels map[string]*chatLogChannel
+	hub      *ChatHub
+}
+
+func NewChatLogger(client *helix.Client, hub *ChatHub, usernames []string, usernameIds []string, config models.ConfigurationFile) *ChatLogger {
+	logger := &ChatLogger{client: client, hub: hub, config: config, channels: make(map[string]*chatLogChannel)}
+	for i := range usernames {
+		username := strings.ToLower(usernames[i])
+		logger.channels[username] = &chatLogChannel{Username: username, UsernameId: usernameIds[i]}
+	}
+	return logger
+}
+
+// Run subscribes to the chat of all channels and polls if they are live until stop is closed
+func (logger *ChatLogger) Run(stop chan struct{}) {
+
+	// All channels share the irc connections of the hub
+	var subscriptions []*ChatSubscription
+	for username := range logger.channels {
+		username := username
+		subscription := logger.hub.Subscribe(username, func(message twitchirc.Message) {
+			logger.handle(username, message, ircMessageRaw(message))
+		})
+		subscription.OnOutage(func(outage models.ChatOutage) {
+			logger.outage(username, outage)
+		})
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	// Check which channels are live
+	queryLive := time.Duration(logger.config.QueryLiveMin) * time.Minute
+	if queryLive <= 0 {
+		queryLive = time.Minute
+	}
+	ticker := time.NewTicker(queryLive)
+	defer ticker.Stop()
+	logger.pollStreams()
+	for running := true; running; {
+		select {
+		case <-stop:
+			running = false
+		case <-ticker.C:
+			logger.pollStreams()
+		}
+	}
+
+	// Finally close everything we have open
+	for _, subscription := range subscriptions {
+		subscription.Close()
+	}
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	for _, channel := range logger.channels {
+		if channel.Stream != nil {
+			logger.finishStream(channel, channel.Stream)
+			channel.Stream = nil
+		}
+		if channel.LogFile != nil {
+			_ = channel.LogFile.Close()
+			channel.LogFile = nil
+		}
+	}
+
+}
+
+// pollStreams opens a chat json for each channel that has gone live, and closes it when they go offline
+func (logger *ChatLogger) pollStreams() {
+	for _, channel := range logger.channels {
+		stream, err := twitch.GetLatestStream(logger.client, channel.UsernameId)
+		if err != nil && err != twitch.ErrNoLiveStreams {
+			log.Printf("CHATLOG: %s - %s\n", channel.Username, err)
+			continue
+		}
+
+		// Find the vod of the stream before we lock, since this can take a while
+		vodId := ""
+		logger.mutex.Lock()
+		isNew := err == nil && (channel.Stream == nil || channel.Stream.Stream.ID != stream.ID)
+		logger.mutex.Unlock()
+		if isNew {
+			vod, errVod := twitch.GetVodFromStreamId(logger.client, channel.Username, channel.UsernameId, logger.config, stream)
+			if errVod == nil {
+				vodId = vod.ID
+			}
+		}
+
+		// Switch what stream we are writing into
+		// NOTE: the old chat is finished after we unlock, so we do not hold up the other channels
+		logger.mutex.Lock()
+		ended := channel.Stream
+		if ended != nil && (isNew || err == twitch.ErrNoLiveStreams) {
+			log.Printf("CHATLOG: %s - stream %s has ended\n", channel.Username, ended.Stream.ID)
+			channel.Stream = nil
+		} else {
+			ended = nil
+		}
+		if isNew {
+			logger.openStream(channel, stream, vodId)
+		}
+		logger.mutex.Unlock()
+		if ended != nil {
+			logger.finishStream(channel, ended)
+		}
+	}
+}
+
+// handle writes a message into the daily log of its channel, and into the chat json if the channel is live
+func (logger *ChatLogger) handle(username string, message twitchirc.Message, raw string) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	channel, ok := logger.channels[strings.ToLower(username)]
+	if !ok {
+		return
+	}
+	tm := ircMessageTime(message)
+	logger.writeLog(channel, tm, raw)
+
+	// Remember recent messages since we only poll if the channel is live every so often
+	channel.Recent = append(channel.Recent, chatLogMessage{Time: tm, Message: message})
+	for len(channel.Recent) > 0 && tm.Sub(channel.Recent[0].Time) > 2*time.Duration(logger.config.QueryLiveMin+1)*time.Minute {
+		channel.Recent = channel.Recent[1:]
+	}
+	if channel.Stream != nil {
+		logger.writeStream(channel, message)
+	}
+}
+
+// outage records a time the irc connection was down into the chat json of the live stream
+// NOTE: the daily logs just have nothing for this time
+func (logger *ChatLogger) outage(username string, outage models.ChatOutage) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	channel, ok := logger.channels[strings.ToLower(username)]
+	if !ok || channel.Stream == nil {
+		return
+	}
+	stream := channel.Stream
+	outage.StartOffsetSeconds = outage.Start.Sub(stream.Stream.StartedAt).Seconds()
+	outage.EndOffsetSeconds = outage.End.Sub(stream.Stream.StartedAt).Seconds()
+	log.Printf("CHATLOG: %s - chat was disconnected for %.0f sec\n", channel.Username, outage.DurationSeconds)
+	stream.MetaData.ChatOutages = append(stream.MetaData.ChatOutages, outage)
+	file, _ := json.MarshalIndent(stream.MetaData, "", " ")
+	_ = ioutil.WriteFile(stream.PathInfo, file, 0644)
+	err := stream.Journal.Append(ircOutageComment(outage, stream.VodId))
+	if err != nil {
+		log.Printf("CHATLOG: %s - chat error %s\n", channel.Username, err)
+	}
+}
+
+// writeLog appends the raw line to the log of the day the message was sent on
+func (logger *ChatLogger) writeLog(channel *chatLogChannel, tm time.Time, raw string) {
+	day := tm.UTC().Format("2006-01-02")
+	if channel.LogFile == nil || channel.LogDay != day {
+		if channel.LogFile != nil {
+			_ = channel.LogFile.Close()
+			channel.LogFile = nil
+		}
+		saveDir := filepath.Join(logger.config.SaveDirectory, channel.Username, "chat_logs")
+		err := os.MkdirAll(saveDir, os.ModePerm)
+		if err != nil {
+			log.Printf("CHATLOG: %s - error %s\n", channel.Username, err)
+			return
+		}
+		file, err := os.OpenFile(filepath.Join(saveDir, day+"_irc.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Printf("CHATLOG: %s - error %s\n", channel.Username, err)
+			return
+		}
+		channel.LogFile = file
+		channel.LogDay = day
+	}
+	_, _ = channel.LogFile.Write([]byte(raw + "\n"))
+}
+
+// writeStream converts the message and appends it to the chat json of the live stream
+// NOTE: offsets are from when twitch says the stream started, so they line up with the vod
+func (logger *ChatLogger) writeStream(channel *chatLogChannel, message twitchirc.Message) {
+	var comments []models.Comments
+	switch message := message.(type) {
+	case *twitchirc.PrivateMessage:
+		comments = append(comments, ircPrivateComment(*message))
+	case *twitchirc.UserNoticeMessage:
+		comments = append(comments, ircUserNoticeComment(*message))
+	}
+	for i := range comments {
+		comments[i].ContentId = channel.Stream.VodId
+		comments[i].ContentOffsetSeconds = comments[i].CreatedAt.Sub(channel.Stream.Stream.StartedAt).Seconds()
+		channel.Stream.Moderation.Add(comments[i])
+	}
+	if event, ok := ircModerationEvent(message); ok {
+		event.ContentOffsetSeconds = event.CreatedAt.Sub(channel.Stream.Stream.StartedAt).Seconds()
+		comments = append(comments, channel.Stream.Moderation.Apply(event)...)
+		err := channel.Stream.Events.Append(event)
+		if err != nil {
+			log.Printf("CHATLOG: %s - moderation error %s\n", channel.Username, err)
+		}
+	}
+	err := channel.Stream.Journal.Append(comments...)
+	if err != nil {
+		log.Printf("CHATLOG: %s - chat error %s\n", channel.Username, err)
+	}
+}
+
+// openStream starts a new chat json for the live stream, with any recent messages sent after it started
+func (logger *ChatLogger) openStream(channel *chatLogChannel, stream helix.Stream, vodId string) {
+
+	// Save next to the live recordings, so they can be merged into the vod chat
+	ID := stream.ID
+	if vodId != "" {
+		ID = vodId
+	}
+	yearFolder := strconv.Itoa(stream.StartedAt.Year()) + "-" + fmt.Sprintf("%02d", int(stream.StartedAt.Month()))
+	saveDir := filepath.Join(logger.config.SaveDirectory, channel.Username, yearFolder)
+	err := os.MkdirAll(saveDir, os.ModePerm)
+	if err != nil {
+		log.Printf("CHATLOG: %s - error %s\n", channel.Username, err)
+		return
+	}
+	filePrefix := helpers.NextFilePrefix(saveDir, ID)
+	journal, err := helpers.OpenChatJournal(filepath.Join(saveDir, filePrefix+"_chat.jsonl"))
+	if err != nil {
+		log.Printf("CHATLOG: %s - error %s\n", channel.Username, err)
+		return
+	}
+	events, err := helpers.OpenModerationJournal(filepath.Join(saveDir, filePrefix+"_moderation.jsonl"))
+	if err != nil {
+		log.Printf("CHATLOG: %s - error %s\n", channel.Username, err)
+		_ = journal.Close()
+		return
+	}
+	log.Printf("CHATLOG: %s - stream %s is live, saving chat to %s\n", channel.Username, stream.ID, filePrefix)
+
+	// Write the stream info the file
+	metaData := models.StreamMetaData{}
+	if vodId != "" {
+		metaData.Id = vodId
+		metaData.Url = "https://www.twitch.tv/videos/" + vodId
+	}
+	metaData.IdStream = stream.ID
+	metaData.UserId = stream.UserID
+	metaData.UserName = stream.UserName
+	metaData.Title = stream.Title
+	metaData.Game = stream.GameName
+	metaData.Views = -1
+	metaData.RecordedAt = stream.StartedAt
+	metaData.Titles = make([]models.Moment, 0)
+	metaData.Moments = make([]models.Moment, 0)
+	metaData.MutedSegments = make([]interface{}, 0)
+	metaData.ChatAlignment = &models.ChatAlignment{Reference: "stream_started_at", ReferenceTime: stream.StartedAt, StreamStartedAt: stream.StartedAt}
+	pathInfo := filepath.Join(saveDir, filePrefix+"_info.json")
+	file, _ := json.MarshalIndent(metaData, "", " ")
+	_ = ioutil.WriteFile(pathInfo, file, 0644)
+
+	// Start writing, and add what was said since the stream started
+	channel.Stream = &chatLogStream{
+		Stream:         stream,
+		VodId:          vodId,
+		PathJournal:    journal.Path,
+		PathJson:       filepath.Join(saveDir, filePrefix+"_chat.json"),
+		PathModeration: filepath.Join(saveDir, filePrefix+"_moderation.json"),
+		PathInfo:       pathInfo,
+		MetaData:       metaData,
+		Journal:        journal,
+		Moderation:     newModerationTracker(),
+		Events:         events,
+	}
+	for _, recent := range channel.Recent {
+		if !recent.Time.Before(stream.StartedAt) {
+			logger.writeStream(channel, recent.Message)
+		}
+	}
+
+}
+
+// finishStream writes the chat json of a stream we are no longer writing into
+func (logger *ChatLogger) finishStream(channel *chatLogChannel, stream *chatLogStream) {
+	_ = stream.Journal.Close()
+	_ = stream.Events.Close()
+
+	// Compact the chat journal into the TwitchDownloader chat json
+	emotes := models.Emotes{}
+	if logger.config.EmbedEmotes {
+		emotes = EmbedEmotes(channel.Username, channel.UsernameId, helpers.IterateChatJournal(stream.PathJournal), emotes, logger.config)
+	}
+	if logger.config.EmbedBadges {
+		emotes.TwitchBadges = EmbedBadges(logger.client, channel.Username, channel.UsernameId, helpers.IterateChatJournal(stream.PathJournal), emotes.TwitchBadges)
+	}
+	streamer := models.Streamer{Name: channel.Username}
+	streamer.ID, _ = strconv.Atoi(channel.UsernameId)
+	_, err := helpers.CompactModerationJournal(stream.Events.Path, stream.PathModeration, streamer, nil)
+	if err != nil {
+		log.Printf("CHATLOG: %s - moderation error %s\n", channel.Username, err)
+	}
+	count, err := helpers.CompactChatJournal(stream.PathJournal, stream.PathJson, streamer, time.Since(stream.Stream.StartedAt).Seconds(), emotes)
+	if err != nil {
+		log.Printf("CHATLOG: %s - chat error %s\n", channel.Username, err)
+		return
+	}
+	log.Printf("CHATLOG: %s - saved %d comments to %s\n", channel.Username, count, filepath.Base(stream.PathJson))
+}
@@ -0,0 +1,97 @@
+package algos
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/models"
+	"sync"
+	"time"
+)
+
+// chatRelayBacklog is how long we remember messages, so a part which starts after another has ended still gets them
+// NOTE: a new part starts a few segments back from live, so its first frames are from before it was started
+const chatRelayBacklog = 2 * time.Minute
+
+// chatRelayHandler is a part of a recording which wants the messages of the channel
+type chatRelayHandler struct {
+	handler       ChatMessageHandler
+	outageHandler ChatOutageHandler
+	addedAt       time.Time
+}
+
+// chatRelay keeps a single subscription to a channel for every part of a broadcast, and passes its messages to them
+// This way we never leave the channel between parts, and a new part is sent the recent messages it would have missed
+type chatRelay struct {
+	mutex        sync.Mutex
+	subscription *ChatSubscription
+	handlers     map[int]*chatRelayHandler
+	nextId       int
+	recent       []chatLogMessage
+}
+
+// newChatRelay subscribes to the channel, Close should be called once all parts have been recorded
+func newChatRelay(hub *ChatHub, channel string) *chatRelay {
+	relay := &chatRelay{handlers: make(map[int]*chatRelayHandler)}
+	relay.subscription = hub.Subscribe(channel, relay.message)
+	relay.subscription.OnOutage(relay.outage)
+	return relay
+}
+
+// Add calls handler for every message from now on, after calling it for the recent ones
+// The returned function removes the handler, if the connection is down the outage up till now is sent to it first
+// NOTE: this waits for the handlers to finish, so they are never called again after remove returns
+func (relay *chatRelay) Add(handler ChatMessageHandler, outageHandler ChatOutageHandler) func() {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	id := relay.nextId
+	relay.nextId++
+	relay.handlers[id] = &chatRelayHandler{handler: handler, outageHandler: outageHandler, addedAt: time.Now()}
+	for _, recent := range relay.recent {
+		handler(recent.Message)
+	}
+	return func() {
+		if downSince := relay.subscription.downSince(); !downSince.IsZero() {
+			relay.outage(models.ChatOutage{Start: downSince, End: time.Now(), DurationSeconds: time.Since(downSince).Seconds()})
+		}
+		relay.mutex.Lock()
+		defer relay.mutex.Unlock()
+		delete(relay.handlers, id)
+	}
+}
+
+// Close leaves the channel if no one else is subscribed to it
+func (relay *chatRelay) Close() {
+	relay.subscription.Close()
+}
+
+// message remembers the message and sends it to every handler
+func (relay *chatRelay) message(message twitchirc.Message) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	now := time.Now()
+	relay.recent = append(relay.recent, chatLogMessage{Time: now, Message: message})
+	for len(relay.recent) > 0 && now.Sub(relay.recent[0].Time) > chatRelayBacklog {
+		relay.recent = relay.recent[1:]
+	}
+	for _, handler := range relay.handlers {
+		handler.handler(message)
+	}
+}
+
+// outage sends the outage to every handler, only the part after it was added
+func (relay *chatRelay) outage(outage models.ChatOutage) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	for _, handler := range relay.handlers {
+		if handler.outageHandler == nil {
+			continue
+		}
+		start := outage.Start
+		if start.Before(handler.addedAt) {
+			start = handler.addedAt
+		}
+		if !outage.End.After(start) {
+			continue
+		}
+		handler.outageHandler(models.ChatOutage{Start: start, End: outage.End, DurationSeconds: outage.End.Sub(start).Seconds()})
+	}
+}
@@ -0,0 +1,143 @@
+package algos
+
+import (
+	"fmt"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/grafov/m3u8"
+	"github.com/nicklaw5/helix"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// selectClipSegments walks the cumulative duration of the playlist and returns the segments which cover [from, to)
+// Also returns the offset in the vod that the first returned segment starts at
+func selectClipSegments(segments []*m3u8.MediaSegment, from float64, to float64) ([]*m3u8.MediaSegment, []int, float64) {
+	var selected []*m3u8.MediaSegment
+	var indices []int
+	firstStart := -1.0
+	currentStart := 0.0
+	for idx, segment := range segments {
+		if segment == nil {
+			continue
+		}
+		currentEnd := currentStart + segment.Duration
+		if currentStart < to && currentEnd > from {
+			if firstStart < 0 {
+				firstStart = currentStart
+			}
+			selected = append(selected, segment)
+			indices = append(indices, idx)
+		}
+		currentStart = currentEnd
+	}
+	return selected, indices, firstStart
+}
+
+// DownloadVodClip will download only the segments of the vod between the from and to offsets (in seconds)
+// The segments are then trimmed with ffmpeg to the exact range requested
+// If copyCodec is true we do not re-encode, which is faster but will cut on the nearest keyframes
+func DownloadVodClip(client *helix.Client, username string, config models.ConfigurationFile, vod helix.Video, from float64, to float64, copyCodec bool) {
+
+	// Check our range is valid
+	if from < 0 || to <= from {
+		log.Printf("CLIP: %s - invalid range %.2f to %.2f\n", username, from, to)
+		return
+	}
+
+	// Get the media playlist of the quality we want
+	variant, _, segmentPlaylist, err := getVodPlaylist(username, vod, config)
+	if err != nil {
+		log.Printf("CLIP: %s - error %s\n", username, err)
+		return
+	}
+
+	// Find what segments cover the range we want
+	segments, indices, firstStart := selectClipSegments(segmentPlaylist.Segments, from, to)
+	if len(segments) < 1 {
+		log.Printf("CLIP: %s - no segments found between %.2f and %.2f\n", username, from, to)
+		return
+	}
+	log.Printf("CLIP: %s - range needs %d segments (starting at %.2f)\n", username, len(segments), firstStart)
+
+	// Parse VOD date
+	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
+	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+
+	// Create file / folders if needed to save into
+	// NOTE: the segments go into their own folder so we never interfere with a full vod download
+	clipName := vod.ID + "_" + strconv.Itoa(int(from)) + "-" + strconv.Itoa(int(to))
+	saveDir := filepath.Join(config.SaveDirectory, strings.ToLower(username), yearFolder, clipName)
+	err = os.MkdirAll(saveDir, os.ModePerm)
+	if err != nil {
+		log.Printf("CLIP: %s - error %s", username, err)
+		return
+	}
+
+	// Download segments we don't already have
+	var jobs []segmentJob
+	for i, segment := range segments {
+		saveFile := filepath.Join(saveDir, segment.URI)
+		if segmentExistsOnDisk(saveFile) {
+			continue
+		}
+		segmentRemoteUri := variant.URI[0:strings.LastIndex(variant.URI, "/")] + "/" + segment.URI
+		jobs = append(jobs, segmentJob{Index: indices[i], Name: segment.URI, Url: segmentRemoteUri, SaveFile: saveFile})
+	}
+	results := downloadSegments(username, jobs, len(segmentPlaylist.Segments), config.DownloadWorkers, config.DownloadRetries)
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("CLIP: %s - failed to download %s, re-run to resume", username, result.Name)
+			return
+		}
+	}
+
+	// Write the concat list for ffmpeg
+	pathList := filepath.Join(saveDir, "concat.txt")
+	_, err = writeConcatList(pathList, segments)
+	if err != nil {
+		log.Printf("CLIP: %s - error %s", username, err)
+		return
+	}
+
+	// Trim the segments down to the requested range
+	// NOTE: seeking before the input is fast but snaps to keyframes, after the input is frame accurate
+	container := config.VodContainer
+	if container == "" {
+		container = "mp4"
+	}
+	container = strings.TrimPrefix(strings.ToLower(container), ".")
+	pathVideo := filepath.Join(filepath.Dir(saveDir), clipName+"."+container)
+	offset := strconv.FormatFloat(from-firstStart, 'f', 3, 64)
+	duration := strconv.FormatFloat(to-from, 'f', 3, 64)
+	args := []string{"-y", "-err_detect", "ignore_err"}
+	if copyCodec {
+		args = append(args, "-ss", offset, "-f", "concat", "-safe", "0", "-i", pathList, "-t", duration, "-c", "copy")
+	} else {
+		args = append(args, "-f", "concat", "-safe", "0", "-i", pathList, "-ss", offset, "-t", duration, "-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-c:a", "aac")
+	}
+	args = append(args, pathVideo)
+	timeConversion := time.Now()
+	cmd := exec.Command(config.Ffmpeg, args...)
+	cmd.Stdout = os.Stdout
+	err = cmd.Start()
+	if err != nil {
+		log.Printf("CLIP: %s - ffmpeg start error %s\n", username, err)
+		return
+	}
+	err = cmd.Wait()
+	if err != nil {
+		log.Printf("CLIP: %s - ffmpeg error %s\n", username, err)
+		return
+	}
+	log.Printf("CLIP: %s - ffmpeg trimmed clip in %s!\n", username, time.Since(timeConversion).String())
+	log.Printf("CLIP: %s - saved %s\n", username, pathVideo)
+
+	// Cleanup the segments since we have our clip
+	_ = os.RemoveAll(saveDir)
+
+}
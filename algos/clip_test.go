@@ -0,0 +1,33 @@
+package algos
+
+import (
+	"github.com/grafov/m3u8"
+	"reflect"
+	"testing"
+)
+
+func TestSelectClipSegments(t *testing.T) {
+	// Segments are 10 sec long except the last one, with a null one in the middle
+	var segments []*m3u8.MediaSegment
+	for i := 0; i < 5; i++ {
+		segments = append(segments, &m3u8.MediaSegment{SeqId: uint64(i), Duration: 10})
+	}
+	segments = append(segments, nil, &m3u8.MediaSegment{SeqId: 5, Duration: 4})
+
+	check := func(from float64, to float64, wantIndices []int, wantStart float64) {
+		t.Helper()
+		selected, indices, firstStart := selectClipSegments(segments, from, to)
+		if !reflect.DeepEqual(indices, wantIndices) || len(selected) != len(indices) {
+			t.Errorf("%.0f-%.0f: got %d segments %v, want %v", from, to, len(selected), indices, wantIndices)
+		}
+		if firstStart != wantStart {
+			t.Errorf("%.0f-%.0f: got first start %.2f, want %.2f", from, to, firstStart, wantStart)
+		}
+	}
+	check(12, 18, []int{1}, 10)
+	check(10, 30, []int{1, 2}, 10)
+	check(5, 25, []int{0, 1, 2}, 0)
+	check(45, 100, []int{4, 6}, 40)
+	check(0, 54, []int{0, 1, 2, 3, 4, 6}, 0)
+	check(60, 70, nil, -1)
+}
@@ -0,0 +1,109 @@
+package algos
+
+import (
+	"encoding/base64"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"log"
+	"strings"
+)
+
+// EmbedEmotes downloads the image of every emote used in the comments so the chat can be rendered offline
+// First party emotes are found from the message fragments, third party (BTTV, FFZ, 7TV) from the words of each message
+// Emotes which are already embedded in existing are kept as-is, since they might have been deleted since
+func EmbedEmotes(username string, channelId string, comments helpers.CommentIterator, existing models.Emotes, config models.ConfigurationFile) models.Emotes {
+
+	// Start from what we have already embedded
+	providers := twitch.DefaultEmoteProviders(config.EmoteProviders)
+	emotes := models.Emotes{}
+	emotes.Firstparty = make([]models.Firstparty, 0)
+	emotes.Thirdparty = make([]models.Thirdparty, 0)
+	emotes.TwitchBadges = existing.TwitchBadges
+	haveFirstparty := make(map[string]bool)
+	haveThirdparty := make(map[string]bool)
+	for _, emote := range existing.Firstparty {
+		emotes.Firstparty = append(emotes.Firstparty, emote)
+		haveFirstparty[emote.ID] = true
+	}
+	for _, emote := range existing.Thirdparty {
+		emotes.Thirdparty = append(emotes.Thirdparty, emote)
+		haveThirdparty[emote.Name] = true
+	}
+
+	// Find all first party emotes and words used in chat
+	firstpartyIds := make(map[string]bool)
+	words := make(map[string]bool)
+	comments(func(comment models.Comments) {
+		for _, emote := range comment.Message.Emoticons {
+			firstpartyIds[emote.Id] = true
+		}
+		for _, fragment := range comment.Message.Fragments {
+			if fragment.Emoticon != nil {
+				firstpartyIds[fragment.Emoticon.EmoticonId] = true
+				continue
+			}
+			for _, word := range strings.Fields(fragment.Text) {
+				words[word] = true
+			}
+		}
+	})
+
+	// Download first party emotes we do not have
+	for id := range firstpartyIds {
+		if id == "" || haveFirstparty[id] {
+			continue
+		}
+		data, err := twitch.DownloadImage(twitch.TwitchEmoteUrl(providers, id))
+		if err != nil {
+			log.Printf("EMOTES: %s - unable to get emote %s: %s\n", username, id, err)
+			continue
+		}
+		emote := models.Firstparty{}
+		emote.ID = id
+		emote.Imagescale = 2
+		emote.Data = base64.StdEncoding.EncodeToString(data)
+		emotes.Firstparty = append(emotes.Firstparty, emote)
+		haveFirstparty[id] = true
+	}
+
+	// Get the third party emotes of this channel
+	// NOTE: if two providers have the same name, the first one here wins
+	var thirdparty []models.ThirdPartyEmote
+	providerNames := []string{"7tv", "bttv", "ffz"}
+	providerGetters := []func(models.EmoteProviders, string) ([]models.ThirdPartyEmote, error){
+		twitch.GetSevenTvEmotes,
+		twitch.GetBttvEmotes,
+		twitch.GetFfzEmotes,
+	}
+	for i, getter := range providerGetters {
+		list, err := getter(providers, channelId)
+		if err != nil {
+			log.Printf("EMOTES: %s - unable to get %s emotes: %s\n", username, providerNames[i], err)
+			continue
+		}
+		thirdparty = append(thirdparty, list...)
+	}
+
+	// Download third party emotes which are used in chat
+	for _, emote := range thirdparty {
+		if !words[emote.Name] || haveThirdparty[emote.Name] {
+			continue
+		}
+		data, err := twitch.DownloadImage(emote.ImageUrl)
+		if err != nil {
+			log.Printf("EMOTES: %s - unable to get emote %s: %s\n", username, emote.Name, err)
+			continue
+		}
+		tmp := models.Thirdparty{}
+		tmp.ID = emote.ID
+		tmp.Name = emote.Name
+		tmp.Imagescale = emote.ImageScale
+		tmp.Data = base64.StdEncoding.EncodeToString(data)
+		emotes.Thirdparty = append(emotes.Thirdparty, tmp)
+		haveThirdparty[emote.Name] = true
+	}
+	log.Printf("EMOTES: %s - embedded %d first party and %d third party emotes\n", username, len(emotes.Firstparty), len(emotes.Thirdparty))
+	return emotes
+
+}
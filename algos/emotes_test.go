@@ -0,0 +1,102 @@
+package algos
+
+import (
+	"encoding/base64"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmbedEmotes(t *testing.T) {
+
+	// Each image is its own path, so we can tell which provider it came from
+	responses := map[string]string{
+		"/3/cached/emotes/global":                 `[{"id":"b1","code":"Shared"},{"id":"b2","code":"bttvUsed"},{"id":"b3","code":"bttvUnused"}]`,
+		"/3/cached/users/twitch/123":              `{"channelEmotes":[],"sharedEmotes":[]}`,
+		"/3/cached/frankerfacez/emotes/global":    `[{"id":1,"code":"ffzUsed","images":{"1x":"","2x":"SERVER/ffz/1"}}]`,
+		"/3/cached/frankerfacez/users/twitch/123": `[]`,
+		"/v3/emote-sets/global":                   `{"emotes":[{"id":"s1","name":"Shared"}]}`,
+		"/v3/users/twitch/123":                    `{"emote_set":{"emotes":[{"id":"s2","name":"Existing"}]}}`,
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := responses[r.URL.Path]; ok {
+			_, _ = w.Write([]byte(strings.Replace(body, "SERVER", server.URL, -1)))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/emoticons/v2/") || strings.HasPrefix(r.URL.Path, "/bttv/") ||
+			strings.HasPrefix(r.URL.Path, "/7tv/") || strings.HasPrefix(r.URL.Path, "/ffz/") {
+			_, _ = w.Write([]byte(r.URL.Path))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	config := models.ConfigurationFile{}
+	config.EmoteProviders = models.EmoteProviders{
+		TwitchCdn:  server.URL,
+		BttvApi:    server.URL,
+		BttvCdn:    server.URL + "/bttv",
+		SevenTvApi: server.URL,
+		SevenTvCdn: server.URL + "/7tv",
+	}
+
+	comments := []models.Comments{
+		{Message: models.Message{
+			Emoticons: []models.Emoticon{{Id: "25"}},
+			Fragments: []models.Fragment{{Text: "hello Shared bttvUsed"}},
+		}},
+		{Message: models.Message{
+			Fragments: []models.Fragment{
+				{Text: "Kappa", Emoticon: &models.EmoticonFragment{EmoticonId: "25"}},
+				{Text: "LUL", Emoticon: &models.EmoticonFragment{EmoticonId: "425618"}},
+				{Text: " ffzUsed Existing"},
+			},
+		}},
+	}
+	existing := models.Emotes{
+		Thirdparty: []models.Thirdparty{{ID: "old", Name: "Existing", Imagescale: 1, Data: "old"}},
+		Firstparty: []models.Firstparty{{ID: "425618", Imagescale: 1, Data: "old"}},
+	}
+	emotes := EmbedEmotes("test", "123", helpers.IterateComments(comments), existing, config)
+
+	firstparty := make(map[string]string)
+	for _, emote := range emotes.Firstparty {
+		firstparty[emote.ID] = emote.Data
+	}
+	wantFirstparty := map[string]string{
+		"425618": "old",
+		"25":     base64.StdEncoding.EncodeToString([]byte("/emoticons/v2/25/default/dark/2.0")),
+	}
+	if len(firstparty) != len(wantFirstparty) || len(emotes.Firstparty) != len(wantFirstparty) {
+		t.Errorf("got first party emotes %v, want %v", firstparty, wantFirstparty)
+	}
+	for id, data := range wantFirstparty {
+		if firstparty[id] != data {
+			t.Errorf("first party emote %s has data %q, want %q", id, firstparty[id], data)
+		}
+	}
+
+	thirdparty := make(map[string]string)
+	for _, emote := range emotes.Thirdparty {
+		thirdparty[emote.Name] = emote.Data
+	}
+	wantThirdparty := map[string]string{
+		"Existing": "old",
+		"Shared":   base64.StdEncoding.EncodeToString([]byte("/7tv/emote/s1/2x.webp")),
+		"bttvUsed": base64.StdEncoding.EncodeToString([]byte("/bttv/emote/b2/2x")),
+		"ffzUsed":  base64.StdEncoding.EncodeToString([]byte("/ffz/1")),
+	}
+	if len(thirdparty) != len(wantThirdparty) || len(emotes.Thirdparty) != len(wantThirdparty) {
+		t.Errorf("got third party emotes %v, want %v", thirdparty, wantThirdparty)
+	}
+	for name, data := range wantThirdparty {
+		if thirdparty[name] != data {
+			t.Errorf("third party emote %s has data %q, want %q", name, thirdparty[name], data)
+		}
+	}
+
+}
@@ -0,0 +1,179 @@
+package algos
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// ircComment creates the VOD comment of a chat message from a user
+// NOTE: the content id and offset are not known here, so the caller needs to set them
+func ircComment(id string, roomId string, message string, user twitchirc.User, emotes []*twitchirc.Emote) models.Comments {
+
+	// Create the VOD comment!
+	comment := models.Comments{}
+	comment.Id = id
+	comment.ChannelId = roomId
+	comment.ContentType = "video"
+	comment.Commenter.DisplayName = user.DisplayName
+	comment.Commenter.Id = user.ID
+	comment.Commenter.Name = user.Name
+	comment.Commenter.Type = "user"
+	comment.Source = "chat"
+	comment.State = "published"
+	comment.MoreReplies = false
+	comment.Message.Body = message
+	if len(user.Color) > 0 {
+		color := user.Color
+		comment.Message.UserColor = &color
+	}
+
+	// Loop through all user badges (sub, mod, etc..)
+	for id, ver := range user.Badges {
+		userbadge := models.UserBadge{}
+		userbadge.Id = id
+		userbadge.Version = strconv.Itoa(ver)
+		comment.Message.UserBadges = append(comment.Message.UserBadges, userbadge)
+	}
+
+	// Our emotes provide their ids, name, along with positions in the message
+	for _, emote := range emotes {
+		for _, pos := range emote.Positions {
+			tmp := models.Emoticon{}
+			tmp.Id = emote.ID
+			tmp.Begin = pos.Start
+			tmp.End = pos.End
+			comment.Message.Emoticons = append(comment.Message.Emoticons, tmp)
+		}
+	}
+	return comment
+
+}
+
+// ircFragments splits the body into text and emote fragments
+// NOTE: twitch gives emote positions in characters (not bytes)
+func ircFragments(body string, emoticons []models.Emoticon) []models.Fragment {
+	var fragments []models.Fragment
+	currentEmote := -1
+	fragCurrent := models.Fragment{}
+	pos := 0
+	for _, ch := range body {
+		// find what emote index this current char should be
+		newEmote := -1
+		for e, emote := range emoticons {
+			if pos >= emote.Begin && pos <= emote.End {
+				newEmote = e
+				break
+			}
+		}
+		// loop through all emotes and see if next char should be in an emote
+		if newEmote != currentEmote {
+			if len(fragCurrent.Text) > 0 {
+				fragments = append(fragments, fragCurrent)
+			}
+			fragCurrent = models.Fragment{}
+			if newEmote >= 0 {
+				fragCurrent.Emoticon = &models.EmoticonFragment{}
+				fragCurrent.Emoticon.EmoticonId = emoticons[newEmote].Id
+			}
+			currentEmote = newEmote
+		}
+		// append the current string
+		fragCurrent.Text += string(ch)
+		pos++
+	}
+	return append(fragments, fragCurrent)
+}
+
+// ircPrivateComment converts a PRIVMSG into a VOD comment
+func ircPrivateComment(message twitchirc.PrivateMessage) models.Comments {
+	comment := ircComment(message.ID, message.RoomID, message.Message, message.User, message.Emotes)
+	comment.CreatedAt = message.Time
+	comment.UpdatedAt = message.Time
+	comment.Message.BitsSpent = message.Bits
+	comment.Message.IsAction = message.Action
+	comment.Message.Fragments = ircFragments(comment.Message.Body, comment.Message.Emoticons)
+	return comment
+}
+
+// ircUserNoticeComment converts a USERNOTICE (subs, raids, etc..) into a VOD comment
+// The system message is put in front of what the user said, so the emote positions are moved after it
+func ircUserNoticeComment(message twitchirc.UserNoticeMessage) models.Comments {
+	body := message.SystemMsg + " " + message.Message
+	comment := ircComment(message.ID, message.RoomID, body, message.User, message.Emotes)
+	skip := utf8.RuneCountInString(message.SystemMsg) + 1
+	for i := range comment.Message.Emoticons {
+		comment.Message.Emoticons[i].Begin += skip
+		comment.Message.Emoticons[i].End += skip
+	}
+	comment.CreatedAt = message.Time
+	comment.UpdatedAt = message.Time
+	msgId := message.MsgID
+	comment.Message.UserNoticeParams = models.UserNoticeParams{MsgId: &msgId}
+	comment.Message.Fragments = ircFragments(body, comment.Message.Emoticons)
+	return comment
+}
+
+// ircMessageTime returns when twitch sent a message
+func ircMessageTime(message twitchirc.Message) time.Time {
+	switch message := message.(type) {
+	case *twitchirc.PrivateMessage:
+		return message.Time
+	case *twitchirc.UserNoticeMessage:
+		return message.Time
+	case *twitchirc.ClearChatMessage:
+		return message.Time
+	case *twitchirc.ClearMessage:
+		return ircTagTime(message.Tags)
+	case *twitchirc.RoomStateMessage:
+		return ircTagTime(message.Tags)
+	case *twitchirc.NoticeMessage:
+		return ircTagTime(message.Tags)
+	}
+	return time.Now()
+}
+
+// ircMessageRaw returns the raw irc line of a message
+func ircMessageRaw(message twitchirc.Message) string {
+	switch message := message.(type) {
+	case *twitchirc.PrivateMessage:
+		return message.Raw
+	case *twitchirc.UserNoticeMessage:
+		return message.Raw
+	case *twitchirc.ClearChatMessage:
+		return message.Raw
+	case *twitchirc.ClearMessage:
+		return message.Raw
+	case *twitchirc.RoomStateMessage:
+		return message.Raw
+	case *twitchirc.NoticeMessage:
+		return message.Raw
+	}
+	return ""
+}
+
+// ircOutageComment creates a system comment at the start of an outage, so viewers know messages are missing
+// NOTE: the offsets of the outage need to be set already
+func ircOutageComment(outage models.ChatOutage, contentId string) models.Comments {
+	comment := models.Comments{}
+	comment.Id = "outage-" + strconv.FormatInt(outage.Start.UnixNano(), 10)
+	comment.CreatedAt = outage.Start
+	comment.UpdatedAt = outage.End
+	comment.ContentType = "video"
+	comment.ContentId = contentId
+	comment.ContentOffsetSeconds = outage.StartOffsetSeconds
+	comment.Commenter.DisplayName = "SYSTEM"
+	comment.Commenter.Name = "system"
+	comment.Commenter.Type = "system"
+	comment.Source = "system"
+	comment.State = "published"
+	comment.Message.Body = "Chat was disconnected for " + time.Duration(outage.DurationSeconds*float64(time.Second)).Round(time.Second).String()
+	comment.Message.Body += ", messages between " + helpers.FormatTimestamp(math.Max(outage.StartOffsetSeconds, 0))
+	comment.Message.Body += " and " + helpers.FormatTimestamp(math.Max(outage.EndOffsetSeconds, 0)) + " are missing"
+	comment.Message.Fragments = []models.Fragment{{Text: comment.Message.Body}}
+	return comment
+}
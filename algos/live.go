@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/helpers"
 	"github.com/goldbattle/twitch_vods/models"
 	"github.com/goldbattle/twitch_vods/twitch"
 	"github.com/nicklaw5/helix"
@@ -22,18 +23,77 @@ import (
 	"time"
 )
 
-func DownloadStreamLiveStreamLink(client *helix.Client, username string, usernameId string, downloadVideo bool, config models.ConfigurationFile) {
+// DownloadStreamLiveStreamLink records the live stream with streamlink, and its chat over irc
+func DownloadStreamLiveStreamLink(client *helix.Client, hub *ChatHub, username string, usernameId string, downloadVideo bool, config models.ConfigurationFile) {
+	downloadStreamLive(client, hub, username, usernameId, downloadVideo, false, config)
+}
+
+// downloadStreamLive records the broadcast one part at a time, starting the next part straight away if one splits or stalls
+// All parts share one chat subscription, and the ones which have ended are finished (e.g. remuxed) in the background
+// NOTE: we wait for every part to be finished before returning, so there is never more than one recording of the channel going
+func downloadStreamLive(client *helix.Client, hub *ChatHub, username string, usernameId string, downloadVideo bool, native bool, config models.ConfigurationFile) {
+
+	// Create a listener for the sigterm to close our threads
+	// https://gist.github.com/uudashr/3cf820e3ba902d3c6387abc82c815e66
+	stop := make(chan struct{})
+	ended := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	defer close(ended)
+	go func() {
+		select {
+		case <-signals:
+			//log.Printf("LIVE: %s - stream ended reqested!\n", username)
+			close(stop)
+		case <-ended:
+		}
+	}()
+
+	// Record each part while the channel is live
+	var relay *chatRelay
+	var finishing sync.WaitGroup
+	defer finishing.Wait()
+	for restartOf := ""; ; {
+
+		// Check if we have a stream that is live
+		//err1 := twitch.TestIfStreamIsLiveM3U8(username)
+		stream, err := twitch.GetLatestStream(client, usernameId)
+		if err != nil {
+			log.Printf("LIVE: %s - %s\n", username, err)
+			return
+		}
+
+		// Our chat comes from the hub which is shared by all recordings, we stay subscribed between our parts
+		if relay == nil {
+			relay = newChatRelay(hub, username)
+			defer relay.Close()
+		}
+		prefix, restarted := recordLivePart(client, relay, stream, username, usernameId, downloadVideo, native, restartOf, stop, &finishing, config)
+		if !restarted {
+			return
+		}
+		restartOf = prefix
+	}
+
+}
+
+// recordLivePart records the video either with streamlink or our own hls recorder (native)
+// Everything else (chat, metadata and the final remux) is the same for both
+// If this is a new part because the last one split or stalled, restartOf is the file prefix of that part
+// Returns the file prefix of the part, and true if the next part should be recorded straight away
+// NOTE: in that case it is finished in the background (added to finishing), so there is no gap before the next part
+func recordLivePart(client *helix.Client, relay *chatRelay, stream helix.Stream, username string, usernameId string, downloadVideo bool, native bool, restartOf string, stop chan struct{}, finishing *sync.WaitGroup, config models.ConfigurationFile) (string, bool) {
 
 	// Our data structures
-	stream := helix.Stream{}
 	vod := helix.Video{}
-
-	// Check if we have a stream that is live
-	//err1 := twitch.TestIfStreamIsLiveM3U8(username)
-	stream, err := twitch.GetLatestStream(client, usernameId)
-	if err != nil {
-		log.Printf("LIVE: %s - %s\n", username, err)
-		return
+	stopRequested := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
 	}
 
 	// Convert the stream id to the vod id that we will save into
@@ -60,6 +120,7 @@ func DownloadStreamLiveStreamLink(client *helix.Client, username string, usernam
 	metaData.Titles = make([]models.Moment, 0)
 	metaData.Moments = make([]models.Moment, 0)
 	metaData.MutedSegments = make([]interface{}, 0)
+	metaData.RestartOf = restartOf
 
 	// Master id we will use
 	ID := stream.ID
@@ -91,43 +152,64 @@ func DownloadStreamLiveStreamLink(client *helix.Client, username string, usernam
 
 	// Create file / folders if needed to save into
 	saveDir := filepath.Join(config.SaveDirectory, strings.ToLower(username), yearFolder)
-	err = os.MkdirAll(saveDir, os.ModePerm)
+	err := os.MkdirAll(saveDir, os.ModePerm)
 	if err != nil {
 		log.Printf("LIVE: %s - error %s", username, err)
-		return
+		return "", false
 	}
 
-	// Loop through and try to create a valid
-	fileCounter := 0
-	filePrefix := ID + "_" + fmt.Sprintf("%03d", fileCounter)
+	// Find a file prefix that is not used by another recording of this stream
+	filePrefix := helpers.NextFilePrefix(saveDir, ID)
 	pathVideo := filepath.Join(saveDir, filePrefix+".mp4")
 	pathVideoTmp := filepath.Join(saveDir, filePrefix+".tmp.mp4")
-	for true {
-		_, err1 := os.Stat(pathVideo)
-		_, err2 := os.Stat(pathVideoTmp)
-		if os.IsNotExist(err1) && os.IsNotExist(err2) {
-			break
-		}
-		fileCounter++
-		filePrefix = ID + "_" + fmt.Sprintf("%03d", fileCounter)
-		pathVideo = filepath.Join(saveDir, filePrefix+".mp4")
-		pathVideoTmp = filepath.Join(saveDir, filePrefix+".tmp.mp4")
-	}
 	//pathVideo, _ = filepath.Abs(pathVideo)
 	//pathVideoTmp, _ = filepath.Abs(pathVideoTmp)
 
 	// Open our log file writter
 	pathLog := filepath.Join(saveDir, filePrefix+"_streamlink.log")
+	if native {
+		pathLog = filepath.Join(saveDir, filePrefix+"_hls.log")
+	}
 	logfile, err := os.Create(pathLog)
 	if err != nil {
 		log.Printf("LIVE: %s - error %s\n", username, err)
-		return
+		return filePrefix, false
 	}
 	defer logfile.Close()
 	logfileWriter := bufio.NewWriter(logfile)
 	defer logfileWriter.Flush()
+
+	// Our own recorder writes the segments straight into the video file, and knows the time of the first one
+	// With streamlink the ad breaks come from what it logs, since only it knows which ones it filtered out of the video
+	// NOTE: everything streamlink prints goes into its log file, and the lines we understand are logged as events
+	var recorder *hlsRecorder
+	var streamlinkOutput *streamlinkOutput
+	if native {
+		recorder = newHlsRecorder(username, liveQualities(config, downloadVideo), pathVideoTmp, config.DownloadRetries, log.New(logfileWriter, "", log.LstdFlags))
+		recorder.cutAds = config.LiveCutAds
+		if config.LiveStallSec > 0 {
+			recorder.stallTimeout = time.Duration(config.LiveStallSec) * time.Second
+		}
+	} else {
+		streamlinkOutput = newStreamlinkOutput(username, logfileWriter)
+		defer streamlinkOutput.Close()
+	}
+	adBreaks := func(reference time.Time) []models.AdBreak {
+		if recorder != nil {
+			return recorder.ads.Breaks(reference, recorder.cutAds)
+		}
+		return streamlinkOutput.AdBreaks(reference)
+	}
 	log.Printf("LIVE: %s - %s\n", username, pathVideo)
 
+	// Each recording of the broadcast is a part in its index, a long broadcast can be split over many of them
+	pathParts := filepath.Join(saveDir, ID+"_parts.json")
+	part := models.LivePart{Prefix: filePrefix, RestartOf: restartOf, StartedAt: time.Now()}
+	err = helpers.UpdateLivePart(pathParts, ID, stream.ID, stream.UserName, part)
+	if err != nil {
+		log.Printf("LIVE: %s - parts error %s\n", username, err)
+	}
+
 	// Write the video info the file
 	pathInfoJson := filepath.Join(saveDir, filePrefix+"_info.json")
 	file, _ := json.MarshalIndent(metaData, "", " ")
@@ -136,265 +218,270 @@ func DownloadStreamLiveStreamLink(client *helix.Client, username string, usernam
 	// Chat file writer
 	pathIrcChat := filepath.Join(saveDir, filePrefix+"_irc.log")
 	pathIrcChatJson := filepath.Join(saveDir, filePrefix+"_chat.json")
+	pathIrcChatJournal := filepath.Join(saveDir, filePrefix+"_chat.jsonl")
 	fileIrc, err := os.Create(pathIrcChat)
 	if err != nil {
 		log.Printf("LIVE: %s - error %s\n", username, err)
-		return
+		return filePrefix, false
 	}
 	defer fileIrc.Close()
 
-	// Our comment object we will save to file and its multi-threaded mutex
+	// Each comment is appended to the chat journal as it comes in, the chat json is only created once the stream ends
+	// NOTE: the journal can be compacted at any time if the recording is killed before then
 	ircChatMutex := sync.Mutex{}
-	ircChatComments := []models.Comments{}
-	ircChatLastSaveTime := time.Now()
+	ircChatJournal, err := helpers.OpenChatJournal(pathIrcChatJournal)
+	if err != nil {
+		log.Printf("LIVE: %s - error %s\n", username, err)
+		return filePrefix, false
+	}
+	defer ircChatJournal.Close()
+
+	// Moderation events are appended to their own journal, and the comments they remove are flagged
+	// NOTE: the journal is turned into the moderation timeline once the stream ends
+	pathModerationJson := filepath.Join(saveDir, filePrefix+"_moderation.json")
+	moderationJournal, err := helpers.OpenModerationJournal(filepath.Join(saveDir, filePrefix+"_moderation.jsonl"))
+	if err != nil {
+		log.Printf("LIVE: %s - error %s\n", username, err)
+		return filePrefix, false
+	}
+	defer moderationJournal.Close()
+	moderation := newModerationTracker()
 
 	// Start download of live chat
+	// NOTE: offsets are from the reference time (the first frame we recorded) using the time twitch sent each message
 	ircStartTime := time.Now()
-	ircClient := twitchirc.NewAnonymousClient()
-	ircClient.OnPrivateMessage(func(message twitchirc.PrivateMessage) {
+	ircReference := time.Now()
+	ircStarted := false
+	var ircPending []twitchirc.Message
+
+	// Records a moderation event, must be called while holding the chat mutex
+	// NOTE: flagged comments are appended again, and the journal will keep the newest one when compacted
+	addModerationEvent := func(event models.ModerationEvent) {
+		event.ContentOffsetSeconds = event.CreatedAt.Sub(ircReference).Seconds()
+		err := ircChatJournal.Append(moderation.Apply(event)...)
+		if err != nil {
+			log.Printf("LIVE: %s - chat error %s\n", username, err)
+		}
+		err = moderationJournal.Append(event)
+		if err != nil {
+			log.Printf("LIVE: %s - moderation error %s\n", username, err)
+		}
+	}
+
+	// Converts and saves a message, must be called while holding the chat mutex
+	addMessage := func(message twitchirc.Message) {
 
 		// Create the VOD comment!
-		comment := models.Comments{}
-		comment.Id = message.ID
-		comment.CreatedAt = message.Time
-		comment.UpdatedAt = message.Time
-		comment.ChannelId = message.RoomID
-		comment.ContentType = "video"
-		if errVod == nil {
-			comment.ContentId = vod.ID
-		}
-		comment.ContentOffsetSeconds = time.Since(ircStartTime).Seconds()
-		comment.Commenter.DisplayName = message.User.DisplayName
-		comment.Commenter.Id = message.User.ID
-		comment.Commenter.Name = message.User.Name
-		comment.Commenter.Type = "user"
-		comment.Source = "chat"
-		comment.State = "published"
-		comment.MoreReplies = false
-		comment.Message.Body = message.Message
-		comment.Message.BitsSpent = message.Bits
-		comment.Message.IsAction = message.Action
-		if len(message.User.Color) > 0 {
-			comment.Message.UserColor = &message.User.Color
-		}
-		//isSubscribe := strings.Contains(message.Message, "subscribed")
-
-		// Loop through all user badges (sub, mod, etc..)
-		for id, ver := range message.User.Badges {
-			userbadge := models.UserBadge{}
-			userbadge.Id = id
-			userbadge.Version = strconv.Itoa(ver)
-			comment.Message.UserBadges = append(comment.Message.UserBadges, userbadge)
-		}
-
-		// Our emotes provide their ids, name, along with positions in the message
-		for _, emote := range message.Emotes {
-			for _, pos := range emote.Positions {
-				tmp := models.Emoticon{}
-				tmp.Id = emote.ID
-				tmp.Begin = pos.Start
-				tmp.End = pos.End
-				comment.Message.Emoticons = append(comment.Message.Emoticons, tmp)
+		var comments []models.Comments
+		switch message := message.(type) {
+		case *twitchirc.PrivateMessage:
+			comments = append(comments, ircPrivateComment(*message))
+		case *twitchirc.UserNoticeMessage:
+			comments = append(comments, ircUserNoticeComment(*message))
+		}
+		for i := range comments {
+			if errVod == nil {
+				comments[i].ContentId = vod.ID
 			}
+			comments[i].ContentOffsetSeconds = comments[i].CreatedAt.Sub(ircReference).Seconds()
+			moderation.Add(comments[i])
 		}
 
-		// Loop through our message, and try to split it into fragments
-		currentEmote := -1
-		fragCurrent := models.Fragment{}
-		for pos, ch := range comment.Message.Body {
-			// find what emote index this current char should be
-			newEmote := -1
-			for e, emote := range comment.Message.Emoticons {
-				if pos >= emote.Begin && pos <= emote.End {
-					newEmote = e
-					break
-				}
-			}
-			// loop through all emotes and see if next char should be in an emote
-			if newEmote != currentEmote {
-				if pos != 0 {
-					comment.Message.Fragments = append(comment.Message.Fragments, fragCurrent)
-				}
-				fragCurrent = models.Fragment{}
-				if newEmote > 0 {
-					fragCurrent.Emoticon = &models.EmoticonFragment{}
-					fragCurrent.Emoticon.EmoticonId = comment.Message.Emoticons[newEmote].Id
-				}
-				currentEmote = newEmote
-			}
-			// append the current string
-			fragCurrent.Text += string(ch)
+		// Append to our chat journal
+		err := ircChatJournal.Append(comments...)
+		if err != nil {
+			log.Printf("LIVE: %s - chat error %s\n", username, err)
+		}
+		if event, ok := ircModerationEvent(message); ok {
+			addModerationEvent(event)
+		}
+	}
 
+	// Times that our irc connection was down are saved into the info file, with a system comment in the chat
+	var ircPendingOutages []models.ChatOutage
+	addOutage := func(outage models.ChatOutage) {
+		outage.StartOffsetSeconds = outage.Start.Sub(ircReference).Seconds()
+		outage.EndOffsetSeconds = outage.End.Sub(ircReference).Seconds()
+		log.Printf("LIVE: %s - chat was disconnected for %.0f sec\n", username, outage.DurationSeconds)
+		metaData.ChatOutages = append(metaData.ChatOutages, outage)
+		file, _ := json.MarshalIndent(metaData, "", " ")
+		_ = ioutil.WriteFile(pathInfoJson, file, 0644)
+		contentId := ""
+		if errVod == nil {
+			contentId = vod.ID
 		}
-		comment.Message.Fragments = append(comment.Message.Fragments, fragCurrent)
+		err := ircChatJournal.Append(ircOutageComment(outage, contentId))
+		if err != nil {
+			log.Printf("LIVE: %s - chat error %s\n", username, err)
+		}
+	}
 
-		// Append to comment array
-		// Create data structure to match the twichdownload chat render
-		// https://github.com/lay295/TwitchDownloader/blob/master/TwitchDownloaderCore/ChatDownloader.cs#L77
+	// Our chat comes from the relay, which also sends us the recent messages from before we started (e.g. during the last part)
+	// NOTE: messages are held until we know our reference time, since it is only known once the video file has been created
+	removeChat := relay.Add(func(message twitchirc.Message) {
 		ircChatMutex.Lock()
 		defer ircChatMutex.Unlock()
-		ircChatComments = append(ircChatComments, comment)
-		if time.Since(ircChatLastSaveTime) > 3*time.Minute {
-			data := models.ChatRenderStructure{}
-			data.Streamer.Name = username
-			data.Streamer.ID, _ = strconv.Atoi(usernameId)
-			data.Video.Start = 0.0
-			data.Video.End = time.Since(ircStartTime).Seconds()
-			data.Comments = ircChatComments
-			data.Emotes.Firstparty = make([]models.Firstparty, 0)
-			data.Emotes.Thirdparty = make([]models.Thirdparty, 0)
-			file, _ := json.MarshalIndent(data, "", " ")
-			_ = ioutil.WriteFile(pathIrcChatJson, file, 0644)
-			ircChatLastSaveTime = time.Now()
-		}
-		_, _ = fileIrc.Write([]byte(message.Raw + "\n"))
-	})
-	ircClient.OnUserNoticeMessage(func(message twitchirc.UserNoticeMessage) {
-
-		// Create the VOD comment!
-		comment := models.Comments{}
-		comment.Id = message.ID
-		comment.CreatedAt = message.Time
-		comment.UpdatedAt = message.Time
-		comment.ChannelId = message.RoomID
-		comment.ContentType = "video"
-		if errVod == nil {
-			comment.ContentId = vod.ID
-		}
-		comment.ContentOffsetSeconds = time.Since(ircStartTime).Seconds()
-		comment.Commenter.DisplayName = message.User.DisplayName
-		comment.Commenter.Id = message.User.ID
-		comment.Commenter.Name = message.User.Name
-		comment.Commenter.Type = "user"
-		comment.Source = "chat"
-		comment.State = "published"
-		comment.MoreReplies = false
-		comment.Message.Body = message.SystemMsg + " " + message.Message
-		comment.Message.BitsSpent = 0
-		comment.Message.IsAction = false
-		if len(message.User.Color) > 0 {
-			comment.Message.UserColor = &message.User.Color
-		}
-		comment.Message.UserNoticeParams = models.UserNoticeParams{MsgId: &message.MsgID}
-
-		// Loop through all user badges (sub, mod, etc..)
-		for id, ver := range message.User.Badges {
-			userbadge := models.UserBadge{}
-			userbadge.Id = id
-			userbadge.Version = strconv.Itoa(ver)
-			comment.Message.UserBadges = append(comment.Message.UserBadges, userbadge)
-		}
-
-		// Our emotes provide their ids, name, along with positions in the message
-		for _, emote := range message.Emotes {
-			for _, pos := range emote.Positions {
-				tmp := models.Emoticon{}
-				tmp.Id = emote.ID
-				tmp.Begin = pos.Start
-				tmp.End = pos.End
-				comment.Message.Emoticons = append(comment.Message.Emoticons, tmp)
-			}
+		_, _ = fileIrc.Write([]byte(ircMessageRaw(message) + "\n"))
+		if !ircStarted {
+			ircPending = append(ircPending, message)
+			return
 		}
-
-		// Loop through our message, and try to split it into fragments
-		currentEmote := -1
-		fragCurrent := models.Fragment{}
-		for pos, ch := range comment.Message.Body {
-			// find what emote index this current char should be
-			newEmote := -1
-			for e, emote := range comment.Message.Emoticons {
-				if pos >= emote.Begin && pos <= emote.End {
-					newEmote = e
-					break
-				}
-			}
-			// loop through all emotes and see if next char should be in an emote
-			if newEmote != currentEmote {
-				if pos != 0 {
-					comment.Message.Fragments = append(comment.Message.Fragments, fragCurrent)
-				}
-				fragCurrent = models.Fragment{}
-				if newEmote > 0 {
-					fragCurrent.Emoticon = &models.EmoticonFragment{}
-					fragCurrent.Emoticon.EmoticonId = comment.Message.Emoticons[newEmote].Id
-				}
-				currentEmote = newEmote
-			}
-			// append the current string
-			fragCurrent.Text += string(ch)
+		addMessage(message)
+	}, func(outage models.ChatOutage) {
+		ircChatMutex.Lock()
+		defer ircChatMutex.Unlock()
+		if !ircStarted {
+			ircPendingOutages = append(ircPendingOutages, outage)
+			return
 		}
-		comment.Message.Fragments = append(comment.Message.Fragments, fragCurrent)
+		addOutage(outage)
+	})
 
-		// Append to comment array
-		// Create data structure to match the TwitchDownload chat render
-		// https://github.com/lay295/TwitchDownloader/blob/master/TwitchDownloaderCore/ChatDownloader.cs#L77
+	// Ad breaks are saved into the info file as they are found, must be called while holding the chat mutex
+	saveAdBreaks := func() {
+		metaData.AdBreaks = adBreaks(ircReference)
+		file, _ := json.MarshalIndent(metaData, "", " ")
+		_ = ioutil.WriteFile(pathInfoJson, file, 0644)
+	}
+	onAdBreak := func() {
 		ircChatMutex.Lock()
 		defer ircChatMutex.Unlock()
-		ircChatComments = append(ircChatComments, comment)
-		if time.Since(ircChatLastSaveTime) > 3*time.Minute {
-			data := models.ChatRenderStructure{}
-			data.Streamer.Name = username
-			data.Streamer.ID, _ = strconv.Atoi(usernameId)
-			data.Video.Start = 0.0
-			data.Video.End = time.Since(ircStartTime).Seconds()
-			data.Comments = ircChatComments
-			data.Emotes.Firstparty = make([]models.Firstparty, 0)
-			data.Emotes.Thirdparty = make([]models.Thirdparty, 0)
-			file, _ := json.MarshalIndent(data, "", " ")
-			_ = ioutil.WriteFile(pathIrcChatJson, file, 0644)
-			ircChatLastSaveTime = time.Now()
-		}
-		_, _ = fileIrc.Write([]byte(message.Raw + "\n"))
-	})
-	ircClient.Join(username)
+		if ircStarted {
+			saveAdBreaks()
+		}
+	}
+	if recorder != nil {
+		recorder.onAdBreak = onAdBreak
+	} else {
+		streamlinkOutput.mutex.Lock()
+		streamlinkOutput.onAdBreak = onAdBreak
+		streamlinkOutput.mutex.Unlock()
+	}
+	// NOTE: this is closed once the recorder has exited, even if it never created the video file
+	recordingDone := make(chan struct{})
 	go func() {
 		// wait till video file has been created
 		for true {
 			if _, err := os.Stat(pathVideoTmp); err == nil {
 				break
 			}
-			time.Sleep(250 * time.Millisecond)
+			select {
+			case <-recordingDone:
+				return
+			case <-time.After(250 * time.Millisecond):
+			}
 		}
-		// start recording our chat messages
-		// will return an error on disconnect that we can just ignore
-		ircStartTime = time.Now()
 		currentMomentGameTime = time.Now()
 		currentMomentTitleTime = time.Now()
-		_ = ircClient.Connect()
+
+		// find the time of the first frame, and record how far off the file creation was
+		var alignment models.ChatAlignment
+		if recorder != nil {
+			alignment = chatAlignment(stream, time.Now(), recorder.FirstSegmentTime())
+		} else {
+			alignment = getChatAlignment(username, stream, time.Now(), streamlinkLiveEdge(config.StreamLinkOptions))
+		}
+		log.Printf("LIVE: %s - chat aligned to %s (skew %.2f sec)\n", username, alignment.Reference, alignment.SkewSeconds)
+
+		// start recording our chat messages, with those that were sent after our first frame
+		// NOTE: the first part keeps moderation events from before it (e.g. the room state when we joined)
+		ircChatMutex.Lock()
+		metaData.ChatAlignment = &alignment
+		file, _ := json.MarshalIndent(metaData, "", " ")
+		_ = ioutil.WriteFile(pathInfoJson, file, 0644)
+		ircStartTime = alignment.FileCreatedAt
+		ircReference = alignment.ReferenceTime
+		ircStarted = true
+		for _, message := range ircPending {
+			_, isEvent := ircModerationEvent(message)
+			if (isEvent && restartOf == "") || !ircMessageTime(message).Before(ircReference) {
+				addMessage(message)
+			}
+		}
+		ircPending = nil
+		for _, outage := range ircPendingOutages {
+			if outage.End.After(ircReference) {
+				addOutage(outage)
+			}
+		}
+		ircPendingOutages = nil
+		saveAdBreaks()
+		ircChatMutex.Unlock()
 	}()
 
 	// Open our streamlink!
-	quality := "best"
-	if !downloadVideo {
-		quality = "worst"
+	// NOTE: streamlink accepts a comma separated list of fallback qualities, so we always end with best
+	// NOTE: the watchdogs pass why they stopped the recording over a channel, only the first reason is kept
+	var cmd *exec.Cmd
+	stopReason := make(chan string, 1)
+	setStopReason := func(reason string) {
+		select {
+		case stopReason <- reason:
+		default:
+		}
 	}
-	args := append([]string{"twitch.tv/" + username, quality, "--loglevel", "info", "-o", pathVideoTmp}, config.StreamLinkOptions...)
-	fmt.Printf("%s %s\n", config.Streamlink, strings.Join(args, " "))
-	cmd := exec.Command(config.Streamlink, args...)
-	cmd.Stdout = logfileWriter
-	cmd.Stdout = logfileWriter
-	err = cmd.Start()
-	if err != nil {
-		log.Printf("LIVE: %s - error %s\n", username, err)
-		return
+	if recorder == nil {
+		quality := strings.Join(liveQualities(config, downloadVideo), ",")
+		args := append([]string{"twitch.tv/" + username, quality, "--loglevel", "info", "-o", pathVideoTmp}, config.StreamLinkOptions...)
+		if config.LiveCutAds && !strings.Contains(strings.Join(args, " "), "--twitch-disable-ads") {
+			args = append(args, "--twitch-disable-ads")
+		}
+		fmt.Printf("%s %s\n", config.Streamlink, strings.Join(args, " "))
+		cmd = exec.Command(config.Streamlink, args...)
+		cmd.Stdout = streamlinkOutput.writer
+		cmd.Stderr = streamlinkOutput.writer
+		err = cmd.Start()
+		if err != nil {
+			log.Printf("LIVE: %s - error %s\n", username, err)
+			close(recordingDone)
+			removeChat()
+			return filePrefix, false
+		}
+
+		// Streamlink can hang without exiting, so we kill it if the video stops growing while the channel is live
+		// NOTE: our own recorder stops by itself if no new segments come for the same time
+		if config.LiveStallSec > 0 {
+			go watchRecording(client, username, usernameId, pathVideoTmp, time.Duration(config.LiveStallSec)*time.Second, streamlinkOutput.Paused, recordingDone, func() {
+				setStopReason("stalled")
+				_ = cmd.Process.Kill()
+			})
+		}
 	}
 
-	// Create a listener for the sigterm to close our threads
-	// https://gist.github.com/uudashr/3cf820e3ba902d3c6387abc82c815e66
-	gracefullSigterm := false
+	// Long broadcasts are split into parts, the next one is started straight away like when we stall
+	// NOTE: both recorders start a few segments back from live, so the parts overlap a little instead of having a gap
+	if config.LiveSplitMin > 0 || config.LiveSplitMb > 0 {
+		go watchPartLimits(username, pathVideoTmp, time.Duration(config.LiveSplitMin)*time.Minute, int64(config.LiveSplitMb)*1024*1024, recordingDone, func() {
+			setStopReason("split")
+			if cmd != nil {
+				_ = cmd.Process.Kill()
+			} else {
+				recorder.Stop()
+			}
+		})
+	}
+
+	// Stop recording if we are asked to
 	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		<-c
-		//log.Printf("LIVE: %s - stream ended reqested!\n", username)
-		_ = cmd.Process.Kill()
-		gracefullSigterm = true
+		select {
+		case <-stop:
+		case <-recordingDone:
+			return
+		}
+		if cmd != nil {
+			_ = cmd.Process.Kill()
+		} else {
+			recorder.Stop()
+		}
 	}()
 
 	// Create listener for game and title changes
 	// We will record any changes to the metadata info file!
+	// NOTE: this stops once the recording has, so it is done before we save the info file for the last time
+	pollerDone := make(chan struct{})
 	go func() {
-		for !gracefullSigterm {
+		defer close(pollerDone)
+		for {
 			stream, err := twitch.GetLatestStream(client, usernameId)
 			if err == nil {
 				doSave := false
@@ -436,55 +523,169 @@ func DownloadStreamLiveStreamLink(client *helix.Client, username string, usernam
 					_ = ioutil.WriteFile(pathInfoJson, file, 0644)
 				}
 			}
-			if !gracefullSigterm {
-				time.Sleep(time.Duration(config.QueryLiveMin) * time.Minute)
+			select {
+			case <-recordingDone:
+				return
+			case <-time.After(time.Duration(config.QueryLiveMin) * time.Minute):
 			}
 		}
 	}()
 
 	// Seems to exit with a status 1, when the stream ends...
 	// Not sure if something that we can fix in streamlink, or just assume it has been ok...
-	_ = cmd.Wait()
-	_ = ircClient.Disconnect()
-	log.Printf("LIVE: %s - stream has ended (%s)\n", username, time.Since(ircStartTime).String())
-
-	// Save the chat one more time
-	data := models.ChatRenderStructure{}
-	data.Streamer.Name = username
-	data.Streamer.ID, _ = strconv.Atoi(usernameId)
-	data.Video.Start = 0.0
-	data.Video.End = time.Since(ircStartTime).Seconds()
-	data.Comments = ircChatComments
-	data.Emotes.Firstparty = make([]models.Firstparty, 0)
-	data.Emotes.Thirdparty = make([]models.Thirdparty, 0)
-	file, _ = json.MarshalIndent(data, "", " ")
-	_ = ioutil.WriteFile(pathIrcChatJson, file, 0644)
-
-	// Save the vod info (append the current moment also!)
+	// The reason we stopped is saved into the info file
+	recorderExit := models.RecorderExit{Recorder: "streamlink"}
+	if cmd != nil {
+		err = cmd.Wait()
+		streamlinkOutput.Close()
+		recorderExit.Reason, recorderExit.Message = streamlinkOutput.exitReason(err, stopRequested())
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			recorderExit.ExitCode = exitErr.ExitCode()
+		}
+	} else {
+		err = recorder.Run()
+		recorderExit.Recorder = "hls"
+		recorderExit.Reason = recorder.ExitReason
+		if err != nil {
+			log.Printf("LIVE: %s - hls error %s\n", username, err)
+			recorderExit.Message = err.Error()
+			recorderExit.ExitCode = 1
+		}
+		log.Printf("LIVE: %s - recorded %d segments (%d missed, %d discontinuities, %d token refreshes)\n", username, recorder.Segments, recorder.Missed, recorder.Discontinuities, recorder.Refreshes)
+	}
+	close(recordingDone)
+	<-pollerDone
+	select {
+	case reason := <-stopReason:
+		recorderExit.Reason = reason
+		recorderExit.Message = ""
+	default:
+	}
+	if stopRequested() {
+		recorderExit.Reason = "stopped"
+		recorderExit.Message = ""
+	}
+	if recorderExit.Reason == "stalled" {
+		stallSec := config.LiveStallSec
+		if recorder != nil {
+			stallSec = int(recorder.stallTimeout.Seconds())
+		}
+		recorderExit.Message = "no new video for " + strconv.Itoa(stallSec) + " sec"
+	}
+	recorderExit.Time = time.Now()
+	removeChat()
+	log.Printf("LIVE: %s - stream has ended (%s, %s)\n", username, recorderExit.Reason, time.Since(ircStartTime).String())
+
+	// If we split or stalled while the channel is still live, the next part is recorded straight away and we finish in the background
+	if recorderExit.Reason == "split" || recorderExit.Reason == "stalled" {
+		if _, err := twitch.GetLatestStream(client, usernameId); err == nil {
+			recorderExit.Restarted = true
+		}
+	}
+
+	// Close the current moments, so they end when the recording did and not when we finished
 	currentMomentGame.Duration = int(time.Since(currentMomentGameTime).Seconds())
 	metaData.Moments = append(metaData.Moments, currentMomentGame)
 	currentMomentTitle.Duration = int(time.Since(currentMomentTitleTime).Seconds())
 	metaData.Titles = append(metaData.Titles, currentMomentTitle)
-	file, _ = json.MarshalIndent(metaData, "", " ")
-	_ = ioutil.WriteFile(pathInfoJson, file, 0644)
 
-	// ffmpeg clean the video file
-	timeConversion := time.Now()
-	cmd = exec.Command(config.Ffmpeg, "-err_detect", "ignore_err", "-i", pathVideoTmp, "-c", "copy", pathVideo)
-	//log.Println(cmd)
-	cmd.Stdout = os.Stdout
-	cmd.Stdout = os.Stdout
-	err = cmd.Start()
-	if err != nil {
-		log.Printf("LIVE: %s - ffmpeg start error %s\n", username, err)
-		return
+	// NOTE: this can run in the background after we return, so it only uses its own copies of what it changes
+	finish := func(metaData models.StreamMetaData, part models.LivePart, recorderExit models.RecorderExit) {
+
+		// Compact the chat journal into the TwitchDownloader chat json
+		// https://github.com/lay295/TwitchDownloader/blob/master/TwitchDownloaderCore/ChatDownloader.cs#L77
+		emotes := models.Emotes{}
+		if config.EmbedEmotes {
+			emotes = EmbedEmotes(username, usernameId, helpers.IterateChatJournal(pathIrcChatJournal), emotes, config)
+		}
+		if config.EmbedBadges {
+			emotes.TwitchBadges = EmbedBadges(client, username, usernameId, helpers.IterateChatJournal(pathIrcChatJournal), emotes.TwitchBadges)
+		}
+		// NOTE: if the ad breaks were cut out of the video, the chat is moved while compacting so it still lines up with it
+		metaData.AdBreaks = adBreaks(ircReference)
+		var shift func(offset float64) float64
+		for _, adBreak := range metaData.AdBreaks {
+			if adBreak.Cut {
+				shift = func(offset float64) float64 {
+					return adCutOffset(metaData.AdBreaks, offset)
+				}
+				break
+			}
+		}
+		streamerId, _ := strconv.Atoi(usernameId)
+		_, err := helpers.CompactChatJournalShifted(pathIrcChatJournal, pathIrcChatJson, models.Streamer{Name: username, ID: streamerId}, time.Since(ircReference).Seconds(), emotes, shift)
+		if err != nil {
+			log.Printf("LIVE: %s - chat error %s\n", username, err)
+		}
+		_, err = helpers.CompactModerationJournal(moderationJournal.Path, pathModerationJson, models.Streamer{Name: username, ID: streamerId}, shift)
+		if err != nil {
+			log.Printf("LIVE: %s - moderation error %s\n", username, err)
+		}
+
+		// Move the chat outages to match the cut video too
+		if shift != nil {
+			metaData.ChatOutages = append([]models.ChatOutage(nil), metaData.ChatOutages...)
+			for i := range metaData.ChatOutages {
+				metaData.ChatOutages[i].StartOffsetSeconds = adCutOffset(metaData.AdBreaks, metaData.ChatOutages[i].StartOffsetSeconds)
+				metaData.ChatOutages[i].EndOffsetSeconds = adCutOffset(metaData.AdBreaks, metaData.ChatOutages[i].EndOffsetSeconds)
+			}
+			log.Printf("LIVE: %s - moved chat to match %d cut ad breaks\n", username, len(metaData.AdBreaks))
+		}
+
+		// Save the vod info
+		if recorder != nil {
+			// NOTE: the live playlist url is signed and only valid for a short while, so there is no point keeping it
+			variant := recorder.Variant()
+			variant.Uri = ""
+			metaData.Variant = &variant
+		} else if name := streamlinkOutput.OpenedVariant(); name != "" {
+			metaData.Variant = &models.ManifestVariant{Name: name}
+		}
+		metaData.RecorderExit = &recorderExit
+		file, _ := json.MarshalIndent(metaData, "", " ")
+		_ = ioutil.WriteFile(pathInfoJson, file, 0644)
+
+		// ffmpeg clean the video file
+		// NOTE: if this fails we keep the recording as-is, and the part says it was not remuxed
+		part.EndedAt = recorderExit.Time
+		part.DurationSeconds = recorderExit.Time.Sub(ircReference).Seconds()
+		part.ExitReason = recorderExit.Reason
+		if fi, err := os.Stat(pathVideoTmp); err == nil {
+			part.SizeBytes = fi.Size()
+		}
+		timeConversion := time.Now()
+		cmd := exec.Command(config.Ffmpeg, "-err_detect", "ignore_err", "-i", pathVideoTmp, "-c", "copy", pathVideo)
+		//log.Println(cmd)
+		cmd.Stdout = os.Stdout
+		err = cmd.Start()
+		if err != nil {
+			log.Printf("LIVE: %s - ffmpeg start error %s, keeping %s\n", username, err, pathVideoTmp)
+			part.ExitReason = "remux_error"
+		} else if err = cmd.Wait(); err != nil {
+			log.Printf("LIVE: %s - ffmpeg error %s, keeping %s\n", username, err, pathVideoTmp)
+			part.ExitReason = "remux_error"
+		} else {
+			log.Printf("LIVE: %s - ffpmeg converted stream in %s!\n", username, time.Since(timeConversion).String())
+			if err := os.Remove(pathVideoTmp); err != nil {
+				log.Printf("LIVE: %s - unable to remove %s: %s\n", username, pathVideoTmp, err)
+			}
+		}
+
+		// Finish our part in the index
+		err = helpers.UpdateLivePart(pathParts, ID, stream.ID, stream.UserName, part)
+		if err != nil {
+			log.Printf("LIVE: %s - parts error %s\n", username, err)
+		}
 	}
-	err = cmd.Wait()
-	if err != nil {
-		log.Printf("LIVE: %s - ffmpeg error %s\n", username, err)
-		return
+	if recorderExit.Restarted {
+		finishing.Add(1)
+		go func(metaData models.StreamMetaData, part models.LivePart, recorderExit models.RecorderExit) {
+			defer finishing.Done()
+			finish(metaData, part, recorderExit)
+		}(metaData, part, recorderExit)
+		return filePrefix, true
 	}
-	log.Printf("LIVE: %s - ffpmeg converted stream in %s!\n", username, time.Since(timeConversion).String())
-	os.Remove(pathVideoTmp)
+	finish(metaData, part, recorderExit)
+	return filePrefix, false
 
 }
@@ -0,0 +1,245 @@
+package algos
+
+import (
+	"fmt"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/nicklaw5/helix"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// medianFloat returns the median of the values (they will be sorted in place)
+func medianFloat(values []float64) float64 {
+	sort.Float64s(values)
+	if len(values)%2 == 1 {
+		return values[len(values)/2]
+	}
+	return 0.5 * (values[len(values)/2-1] + values[len(values)/2])
+}
+
+// liveChatShift finds how many seconds need to be added to the live chat offsets to line up with the vod
+// Messages in both chats are used when possible, otherwise we fall back to the timestamps of each message
+func liveChatShift(vodComments helpers.CommentIterator, liveComments helpers.CommentIterator) (float64, bool) {
+
+	// Use messages which are in both
+	// NOTE: we also estimate when the vod started from its own messages in case there are none
+	vodOffsets := make(map[string]float64)
+	var vodStarts []float64
+	vodComments(func(comment models.Comments) {
+		if comment.LiveOnly {
+			return
+		}
+		vodOffsets[comment.Id] = comment.ContentOffsetSeconds
+		if !comment.CreatedAt.IsZero() {
+			vodStarts = append(vodStarts, float64(comment.CreatedAt.UnixNano())/1e9-comment.ContentOffsetSeconds)
+		}
+	})
+	var deltas []float64
+	liveComments(func(comment models.Comments) {
+		if offset, ok := vodOffsets[comment.Id]; ok {
+			deltas = append(deltas, offset-comment.ContentOffsetSeconds)
+		}
+	})
+	if len(deltas) > 0 {
+		return medianFloat(deltas), true
+	}
+
+	// Else line up the live ones to when the vod started
+	if len(vodStarts) < 1 {
+		return 0, false
+	}
+	vodStart := medianFloat(vodStarts)
+	liveComments(func(comment models.Comments) {
+		if !comment.CreatedAt.IsZero() {
+			deltas = append(deltas, float64(comment.CreatedAt.UnixNano())/1e9-vodStart-comment.ContentOffsetSeconds)
+		}
+	})
+	if len(deltas) > 0 {
+		return medianFloat(deltas), true
+	}
+	return 0, false
+
+}
+
+// mergeEmotes combines the embedded emotes and badges of two chat files, keeping what is in a
+func mergeEmotes(a models.Emotes, b models.Emotes) models.Emotes {
+	merged := models.Emotes{}
+	merged.Firstparty = append(make([]models.Firstparty, 0), a.Firstparty...)
+	merged.Thirdparty = append(make([]models.Thirdparty, 0), a.Thirdparty...)
+	merged.TwitchBadges = append(make([]models.TwitchBadge, 0), a.TwitchBadges...)
+	haveFirstparty := make(map[string]bool)
+	for _, emote := range a.Firstparty {
+		haveFirstparty[emote.ID] = true
+	}
+	for _, emote := range b.Firstparty {
+		if !haveFirstparty[emote.ID] {
+			merged.Firstparty = append(merged.Firstparty, emote)
+			haveFirstparty[emote.ID] = true
+		}
+	}
+	haveThirdparty := make(map[string]bool)
+	for _, emote := range a.Thirdparty {
+		haveThirdparty[emote.Name] = true
+	}
+	for _, emote := range b.Thirdparty {
+		if !haveThirdparty[emote.Name] {
+			merged.Thirdparty = append(merged.Thirdparty, emote)
+			haveThirdparty[emote.Name] = true
+		}
+	}
+	badgeIndex := make(map[string]int)
+	for idx, badge := range merged.TwitchBadges {
+		badgeIndex[badge.Name] = idx
+	}
+	for _, badge := range b.TwitchBadges {
+		idx, ok := badgeIndex[badge.Name]
+		if !ok {
+			badgeIndex[badge.Name] = len(merged.TwitchBadges)
+			merged.TwitchBadges = append(merged.TwitchBadges, models.TwitchBadge{Name: badge.Name, Versions: make(map[string]string)})
+			idx = badgeIndex[badge.Name]
+		}
+		for version, data := range badge.Versions {
+			if _, ok := merged.TwitchBadges[idx].Versions[version]; !ok {
+				merged.TwitchBadges[idx].Versions[version] = data
+			}
+		}
+	}
+	return merged
+}
+
+// liveChatIterator returns the comments of a live recording, from its journal if it has one or else its chat json
+func liveChatIterator(liveFile string) (helpers.CommentIterator, models.Emotes, error) {
+	if strings.HasSuffix(liveFile, ".jsonl") {
+		emotes, _ := helpers.LoadChatEmotes(strings.TrimSuffix(liveFile, ".jsonl") + ".json")
+		return helpers.IterateChatJournal(liveFile), emotes, nil
+	}
+	live, err := helpers.LoadChatFile(liveFile)
+	if err != nil {
+		return nil, models.Emotes{}, err
+	}
+	return helpers.IterateComments(live.Comments), live.Emotes, nil
+}
+
+// liveRecordingFinished returns true once the journal of a live recording has been compacted into its chat json
+// The chat json is written when the recording ends, so while it is still going the journal will be newer than it
+func liveRecordingFinished(liveJournal string) bool {
+	fiJournal, err := os.Stat(liveJournal)
+	if err != nil {
+		return false
+	}
+	fiJson, err := os.Stat(strings.TrimSuffix(liveJournal, ".jsonl") + ".json")
+	if err != nil {
+		return false
+	}
+	return !fiJson.ModTime().Before(fiJournal.ModTime())
+}
+
+// MergeLiveChat combines the live irc chat recordings of a vod (<id>_000_chat.json, ...) into the vod chat (<id>_chat.json)
+// The vod chat is used as the reference, and live messages are shifted onto its timeline and de-duplicated by id
+// Messages which only exist in the live recording (e.g. deleted or moderated) are flagged as live only
+// System comments (e.g. that chat was disconnected) are not merged, since the vod chat does not have the same gaps
+func MergeLiveChat(username string, usernameId string, config models.ConfigurationFile, vod helix.Video) {
+
+	// Parse VOD date
+	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
+	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+
+	// Find the live recordings of this vod
+	// NOTE: prefer the journal of a recording since it will have everything even if the recording was killed
+	saveDir := filepath.Join(config.SaveDirectory, strings.ToLower(username), yearFolder)
+	// NOTE: recordings which are still going are skipped, they will be merged once they have finished
+	var liveFiles []string
+	liveJournals, _ := filepath.Glob(filepath.Join(saveDir, vod.ID+"_[0-9][0-9][0-9]_chat.jsonl"))
+	for _, liveJournal := range liveJournals {
+		if !liveRecordingFinished(liveJournal) {
+			log.Printf("MERGE: %s - %s is still recording, skipping\n", username, filepath.Base(liveJournal))
+			continue
+		}
+		liveFiles = append(liveFiles, liveJournal)
+	}
+	liveJsons, _ := filepath.Glob(filepath.Join(saveDir, vod.ID+"_[0-9][0-9][0-9]_chat.json"))
+	for _, liveJson := range liveJsons {
+		if _, err := os.Stat(liveJson + "l"); os.IsNotExist(err) {
+			liveFiles = append(liveFiles, liveJson)
+		}
+	}
+	sort.Strings(liveFiles)
+	if len(liveFiles) < 1 {
+		return
+	}
+
+	// The vod chat journal is our reference
+	journalPath := helpers.GetChatJournalPath(config.SaveDirectory, username, vod)
+	saveFile := strings.TrimSuffix(journalPath, ".jsonl") + ".json"
+	existingIds := make(map[string]bool)
+	err := helpers.ScanChatJournal(journalPath, func(comment models.Comments) {
+		existingIds[comment.Id] = true
+	})
+	if err != nil || len(existingIds) < 1 {
+		log.Printf("MERGE: %s - vod %s, no vod chat to merge into\n", username, vod.ID)
+		return
+	}
+	journal, err := helpers.OpenChatJournal(journalPath)
+	if err != nil {
+		log.Printf("MERGE: %s - error %s\n", username, err)
+		return
+	}
+
+	// Each live recording has its own start time, so each one is shifted on its own
+	// NOTE: the shift is found before appending anything, so live only messages never count as vod messages
+	countAdded := 0
+	emotes, _ := helpers.LoadChatEmotes(saveFile)
+	for _, liveFile := range liveFiles {
+		live, liveEmotes, err := liveChatIterator(liveFile)
+		if err != nil {
+			log.Printf("MERGE: %s - unable to load %s: %s\n", username, liveFile, err)
+			continue
+		}
+		shift, ok := liveChatShift(helpers.IterateChatJournal(journalPath), live)
+		if !ok {
+			log.Printf("MERGE: %s - unable to align %s to the vod\n", username, filepath.Base(liveFile))
+			continue
+		}
+		log.Printf("MERGE: %s - aligning %s by %.2f sec\n", username, filepath.Base(liveFile), shift)
+		var comments []models.Comments
+		live(func(comment models.Comments) {
+			if comment.Id == "" || existingIds[comment.Id] || comment.Source == "system" {
+				return
+			}
+			comment.ContentOffsetSeconds = comment.ContentOffsetSeconds + shift
+			if comment.ContentOffsetSeconds < 0 {
+				comment.ContentOffsetSeconds = 0
+			}
+			comment.ContentId = vod.ID
+			comment.LiveOnly = true
+			comments = append(comments, comment)
+			existingIds[comment.Id] = true
+		})
+		err = journal.Append(comments...)
+		if err != nil {
+			log.Printf("MERGE: %s - error %s\n", username, err)
+			break
+		}
+		countAdded += len(comments)
+		emotes = mergeEmotes(emotes, liveEmotes)
+	}
+	_ = journal.Close()
+
+	// Only re-write the file if the live recordings added something
+	if countAdded < 1 {
+		return
+	}
+	log.Printf("MERGE: %s - vod %s, added %d live only messages\n", username, vod.ID, countAdded)
+	streamerId, _ := strconv.Atoi(usernameId)
+	_, err = helpers.CompactChatJournal(journalPath, saveFile, models.Streamer{Name: username, ID: streamerId}, 0, emotes)
+	if err != nil {
+		log.Printf("MERGE: %s - error %s\n", username, err)
+	}
+
+}
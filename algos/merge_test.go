@@ -0,0 +1,59 @@
+package algos
+
+import (
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"testing"
+	"time"
+)
+
+func TestLiveChatShiftSharedIds(t *testing.T) {
+	vod := []models.Comments{
+		{Id: "a", ContentOffsetSeconds: 110},
+		{Id: "b", ContentOffsetSeconds: 120},
+		{Id: "c", ContentOffsetSeconds: 200},
+		{Id: "d", ContentOffsetSeconds: 500, LiveOnly: true},
+	}
+	live := []models.Comments{
+		{Id: "a", ContentOffsetSeconds: 100},
+		{Id: "b", ContentOffsetSeconds: 110},
+		{Id: "c", ContentOffsetSeconds: 150},
+		{Id: "d", ContentOffsetSeconds: 100},
+	}
+
+	// The median ignores the one message which is off, and live only ones from a past merge
+	shift, ok := liveChatShift(helpers.IterateComments(vod), helpers.IterateComments(live))
+	if !ok || shift != 10 {
+		t.Errorf("got shift %.2f %v, want 10", shift, ok)
+	}
+}
+
+func TestLiveChatShiftCreatedAt(t *testing.T) {
+	start := time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+	vod := []models.Comments{
+		{Id: "a", ContentOffsetSeconds: 10, CreatedAt: start.Add(70 * time.Second)},
+		{Id: "b", ContentOffsetSeconds: 20, CreatedAt: start.Add(80 * time.Second)},
+	}
+	live := []models.Comments{
+		{Id: "x", ContentOffsetSeconds: 5, CreatedAt: start.Add(100 * time.Second)},
+		{Id: "y", ContentOffsetSeconds: 15, CreatedAt: start.Add(110 * time.Second)},
+		{Id: "z", ContentOffsetSeconds: 20},
+	}
+
+	// The vod started 60 sec after start, so the live chat is 35 sec behind it
+	shift, ok := liveChatShift(helpers.IterateComments(vod), helpers.IterateComments(live))
+	if !ok || shift != 35 {
+		t.Errorf("got shift %.2f %v, want 35", shift, ok)
+	}
+}
+
+func TestLiveChatShiftNoData(t *testing.T) {
+	live := []models.Comments{{Id: "x", ContentOffsetSeconds: 5, CreatedAt: time.Now()}}
+	if shift, ok := liveChatShift(helpers.IterateComments(nil), helpers.IterateComments(live)); ok {
+		t.Errorf("got shift %.2f without vod comments", shift)
+	}
+	vod := []models.Comments{{Id: "a", ContentOffsetSeconds: 10}}
+	if shift, ok := liveChatShift(helpers.IterateComments(vod), helpers.IterateComments(live)); ok {
+		t.Errorf("got shift %.2f without times to estimate from", shift)
+	}
+}
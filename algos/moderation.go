@@ -0,0 +1,158 @@
+package algos
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/models"
+	"strconv"
+	"time"
+)
+
+// moderationWindow is how long we remember live comments so a later timeout, ban or delete can flag them
+const moderationWindow = 30 * time.Minute
+
+// moderationMaxComments is the most comments we remember, so a very busy chat can not use up all of our memory
+const moderationMaxComments = 100000
+
+// moderationEntry is a comment we remember, in the order they were added
+type moderationEntry struct {
+	Id        string
+	UserId    string
+	CreatedAt time.Time
+}
+
+// moderationTracker remembers the recent comments of a live chat so moderation events can be applied to them
+// Comments are indexed by their id and by the user that sent them, so an event only looks at the comments it affects
+// NOTE: only the recent ones are kept in memory, the rest of the chat is already in the journal
+type moderationTracker struct {
+	comments map[string]models.Comments
+	users    map[string][]string
+	order    []moderationEntry
+}
+
+func newModerationTracker() *moderationTracker {
+	return &moderationTracker{comments: make(map[string]models.Comments), users: make(map[string][]string)}
+}
+
+// Add remembers a comment, and forgets any which are older than our window
+// NOTE: the window is relative to the newest comment so this also works when replaying an old irc log
+func (tracker *moderationTracker) Add(comment models.Comments) {
+	if _, ok := tracker.comments[comment.Id]; ok {
+		tracker.comments[comment.Id] = comment
+		return
+	}
+	tracker.comments[comment.Id] = comment
+	tracker.users[comment.Commenter.Id] = append(tracker.users[comment.Commenter.Id], comment.Id)
+	tracker.order = append(tracker.order, moderationEntry{Id: comment.Id, UserId: comment.Commenter.Id, CreatedAt: comment.CreatedAt})
+	for len(tracker.order) > 0 {
+		oldest := tracker.order[0]
+		if len(tracker.order) <= moderationMaxComments && comment.CreatedAt.Sub(oldest.CreatedAt) < moderationWindow {
+			break
+		}
+		tracker.forget(oldest)
+		tracker.order = tracker.order[1:]
+	}
+}
+
+// forget removes the oldest comment we remember
+// NOTE: it is also the oldest comment of its user, so it is always the first in their list
+func (tracker *moderationTracker) forget(oldest moderationEntry) {
+	delete(tracker.comments, oldest.Id)
+	ids := tracker.users[oldest.UserId]
+	if len(ids) > 0 && ids[0] == oldest.Id {
+		ids = ids[1:]
+	}
+	if len(ids) == 0 {
+		delete(tracker.users, oldest.UserId)
+	} else {
+		tracker.users[oldest.UserId] = ids
+	}
+}
+
+// Apply flags the comments affected by the event and returns them so they can be written again
+// A delete removes a single message, a timeout or ban removes all of the user's messages, and a clear removes everything
+func (tracker *moderationTracker) Apply(event models.ModerationEvent) []models.Comments {
+	var ids []string
+	switch event.Type {
+	case models.ModerationDelete:
+		ids = []string{event.MessageId}
+	case models.ModerationTimeout, models.ModerationBan:
+		ids = tracker.users[event.UserId]
+	case models.ModerationClear:
+		for _, entry := range tracker.order {
+			ids = append(ids, entry.Id)
+		}
+	}
+	var flagged []models.Comments
+	for _, id := range ids {
+		comment, ok := tracker.comments[id]
+		if !ok || comment.Moderation != nil {
+			continue
+		}
+		comment.Moderation = &models.CommentModeration{}
+		comment.Moderation.Type = event.Type
+		comment.Moderation.ContentOffsetSeconds = event.ContentOffsetSeconds
+		comment.Moderation.Duration = event.Duration
+		comment.UpdatedAt = event.CreatedAt
+		tracker.comments[id] = comment
+		flagged = append(flagged, comment)
+	}
+	return flagged
+}
+
+// ircTagTime returns when twitch sent a message, or now if it is not tagged
+func ircTagTime(tags map[string]string) time.Time {
+	ms, err := strconv.ParseInt(tags["tmi-sent-ts"], 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// ircModerationEvent converts a CLEARCHAT, CLEARMSG, ROOMSTATE, NOTICE or raid USERNOTICE into a moderation event
+// Returns false if the message is not a moderation event
+// NOTE: the stream offset is not known here, so the caller needs to set it
+func ircModerationEvent(message twitchirc.Message) (models.ModerationEvent, bool) {
+	event := models.ModerationEvent{}
+	switch message := message.(type) {
+	case *twitchirc.ClearChatMessage:
+		event.Type = models.ModerationClear
+		event.CreatedAt = message.Time
+		if message.TargetUserID != "" {
+			event.Type = models.ModerationBan
+			if message.BanDuration > 0 {
+				event.Type = models.ModerationTimeout
+			}
+			event.UserId = message.TargetUserID
+			event.UserName = message.TargetUsername
+			event.Duration = message.BanDuration
+		}
+	case *twitchirc.ClearMessage:
+		event.Type = models.ModerationDelete
+		event.CreatedAt = ircTagTime(message.Tags)
+		event.UserName = message.Login
+		event.MessageId = message.TargetMsgID
+		event.Message = message.Message
+	case *twitchirc.RoomStateMessage:
+		event.Type = models.ModerationRoomState
+		event.CreatedAt = ircTagTime(message.Tags)
+		event.State = message.State
+	case *twitchirc.NoticeMessage:
+		event.Type = models.ModerationNotice
+		event.CreatedAt = ircTagTime(message.Tags)
+		event.NoticeId = message.MsgID
+		event.Message = message.Message
+	case *twitchirc.UserNoticeMessage:
+		if message.MsgID != "raid" {
+			return event, false
+		}
+		event.Type = models.ModerationRaid
+		event.CreatedAt = message.Time
+		event.UserId = message.User.ID
+		event.UserName = message.User.Name
+		event.Message = message.SystemMsg
+		event.Viewers, _ = strconv.Atoi(message.MsgParams["msg-param-viewerCount"])
+	default:
+		return event, false
+	}
+	return event, true
+}
@@ -0,0 +1,167 @@
+package algos
+
+import (
+	"errors"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/grafov/m3u8"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var qualityRegex = regexp.MustCompile(`^(\d+)p(\d+)?$`)
+
+// videoQualities returns the ordered quality preferences from the config
+// If the user has not specified any, we fall back to the old single resolution setting
+func videoQualities(config models.ConfigurationFile) []string {
+	if len(config.VideoQualities) > 0 {
+		return config.VideoQualities
+	}
+	if config.VideoResolution != "" {
+		return []string{config.VideoResolution}
+	}
+	return []string{"best"}
+}
+
+// liveQualities returns the quality preferences for a live recording, always falling back to best
+// If we only want the chat then the smallest stream is used
+func liveQualities(config models.ConfigurationFile, downloadVideo bool) []string {
+	if !downloadVideo {
+		return []string{"worst"}
+	}
+	var qualities []string
+	for _, quality := range videoQualities(config) {
+		if strings.EqualFold(strings.TrimSpace(quality), "best") {
+			continue
+		}
+		qualities = append(qualities, quality)
+	}
+	return append(qualities, "best")
+}
+
+// variantName returns the human name of a variant (e.g. 1080p60, audio_only)
+// Twitch puts this in the NAME of the EXT-X-MEDIA which has the same group id as the VIDEO attribute
+func variantName(variant *m3u8.Variant) string {
+	for _, alt := range variant.Alternatives {
+		if alt != nil && alt.GroupId == variant.Video && alt.Name != "" {
+			return alt.Name
+		}
+	}
+	if variant.Name != "" {
+		return variant.Name
+	}
+	if variant.Video != "" && variant.Video != "chunked" {
+		return variant.Video
+	}
+	height, fps := variantHeightFps(variant)
+	if height == 0 {
+		return "audio_only"
+	}
+	name := strconv.Itoa(height) + "p"
+	if fps > 30 {
+		name += strconv.Itoa(fps)
+	}
+	return name
+}
+
+// variantHeightFps returns the vertical resolution and framerate of a variant
+// NOTE: older playlists do not have the FRAME-RATE attribute, so we try the name in that case
+func variantHeightFps(variant *m3u8.Variant) (int, int) {
+	height := 0
+	if idx := strings.Index(variant.Resolution, "x"); idx != -1 {
+		height, _ = strconv.Atoi(variant.Resolution[idx+1:])
+	}
+	fps := int(math.Round(variant.FrameRate))
+	if fps == 0 {
+		for _, alt := range variant.Alternatives {
+			if alt == nil || alt.GroupId != variant.Video {
+				continue
+			}
+			if match := qualityRegex.FindStringSubmatch(alt.Name); match != nil && match[2] != "" {
+				fps, _ = strconv.Atoi(match[2])
+			}
+		}
+	}
+	if fps == 0 && height > 0 {
+		fps = 30
+	}
+	return height, fps
+}
+
+// isAudioOnly returns true if the variant does not have any video
+func isAudioOnly(variant *m3u8.Variant) bool {
+	height, _ := variantHeightFps(variant)
+	return height == 0 || strings.EqualFold(variant.Video, "audio_only")
+}
+
+// selectVariant finds the first quality in the preference list which the master playlist has
+// A preference can be an exact variant name (1080p60), a resolution (1080p) or the keywords best, worst and audio_only
+// If multiple variants match a preference, the one with the highest bandwidth is returned
+func selectVariant(variants []*m3u8.Variant, preferences []string) (int, error) {
+	for _, preference := range preferences {
+		preference = strings.ToLower(strings.TrimSpace(preference))
+		index := -1
+		for idx, variant := range variants {
+			if variant == nil || variant.Iframe {
+				continue
+			}
+			if !variantMatches(variant, preference) {
+				continue
+			}
+			if index == -1 {
+				index = idx
+				continue
+			}
+			if preference == "worst" {
+				if variant.Bandwidth < variants[index].Bandwidth {
+					index = idx
+				}
+			} else if variant.Bandwidth > variants[index].Bandwidth {
+				index = idx
+			}
+		}
+		if index != -1 {
+			return index, nil
+		}
+	}
+	return -1, errors.New("no variant matches requested qualities " + strings.Join(preferences, ","))
+}
+
+// variantMatches checks if a single preference matches the variant
+func variantMatches(variant *m3u8.Variant, preference string) bool {
+	switch preference {
+	case "best", "worst":
+		return !isAudioOnly(variant)
+	case "audio_only", "audio":
+		return isAudioOnly(variant)
+	}
+	if strings.ToLower(variantName(variant)) == preference {
+		return true
+	}
+	match := qualityRegex.FindStringSubmatch(preference)
+	if match == nil || isAudioOnly(variant) {
+		return false
+	}
+	height, fps := variantHeightFps(variant)
+	wantHeight, _ := strconv.Atoi(match[1])
+	if height != wantHeight {
+		return false
+	}
+	if match[2] == "" {
+		return true
+	}
+	wantFps, _ := strconv.Atoi(match[2])
+	return fps == wantFps
+}
+
+// manifestVariant converts the selected variant into what we save to disk
+func manifestVariant(variant *m3u8.Variant) models.ManifestVariant {
+	return models.ManifestVariant{
+		Name:       variantName(variant),
+		Resolution: variant.Resolution,
+		Bandwidth:  variant.Bandwidth,
+		FrameRate:  variant.FrameRate,
+		Uri:        variant.URI,
+	}
+}
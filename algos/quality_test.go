@@ -0,0 +1,80 @@
+package algos
+
+import (
+	"github.com/grafov/m3u8"
+	"testing"
+)
+
+// testVariant creates a twitch style variant, where the name is on the EXT-X-MEDIA of the video group
+func testVariant(name string, resolution string, bandwidth uint32, frameRate float64) *m3u8.Variant {
+	variant := &m3u8.Variant{URI: name + ".m3u8"}
+	variant.Video = name
+	variant.Resolution = resolution
+	variant.Bandwidth = bandwidth
+	variant.FrameRate = frameRate
+	variant.Alternatives = []*m3u8.Alternative{{GroupId: name, Name: name, Type: "VIDEO"}}
+	return variant
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []*m3u8.Variant{
+		testVariant("1080p60", "1920x1080", 8000000, 60),
+		testVariant("720p60", "1280x720", 3500000, 60),
+		testVariant("720p30", "1280x720", 2500000, 30),
+		testVariant("480p", "852x480", 1400000, 30),
+		testVariant("160p", "284x160", 230000, 30),
+		testVariant("audio_only", "", 160000, 0),
+	}
+	iframe := testVariant("1080p60", "1920x1080", 9000000, 60)
+	iframe.Iframe = true
+	variants = append(variants, iframe, nil)
+
+	tests := []struct {
+		name        string
+		preferences []string
+		want        int
+	}{
+		{"exact name", []string{"720p30"}, 2},
+		{"resolution picks highest bandwidth", []string{"720p"}, 1},
+		{"resolution and fps", []string{"720p60"}, 1},
+		{"case and spaces", []string{" 1080P60 "}, 0},
+		{"best skips iframe and audio", []string{"best"}, 0},
+		{"worst skips audio", []string{"worst"}, 4},
+		{"audio only", []string{"audio_only"}, 5},
+		{"audio alias", []string{"audio"}, 5},
+		{"falls back in order", []string{"1440p", "480p", "best"}, 3},
+		{"missing fps does not match", []string{"480p60", "160p"}, 4},
+		{"nothing matches", []string{"1440p", "4k"}, -1},
+	}
+	for _, test := range tests {
+		index, err := selectVariant(variants, test.preferences)
+		if index != test.want {
+			t.Errorf("%s: got variant %d, want %d", test.name, index, test.want)
+		}
+		if (err != nil) != (test.want == -1) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestVariantName(t *testing.T) {
+	if name := variantName(testVariant("720p60", "1280x720", 0, 60)); name != "720p60" {
+		t.Errorf("got name %s for a named variant", name)
+	}
+	if name := variantName(&m3u8.Variant{}); name != "audio_only" {
+		t.Errorf("got name %s for a variant without video", name)
+	}
+
+	// Older playlists have no frame rate, and some only have a resolution
+	old := &m3u8.Variant{}
+	old.Resolution = "1280x720"
+	old.Bandwidth = 3000000
+	old.Video = "chunked"
+	if name := variantName(old); name != "720p" {
+		t.Errorf("got name %s for a variant with only a resolution", name)
+	}
+	index, err := selectVariant([]*m3u8.Variant{old}, []string{"720p30"})
+	if err != nil || index != 0 {
+		t.Errorf("old variant should default to 30 fps, got %d %v", index, err)
+	}
+}
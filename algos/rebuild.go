@@ -0,0 +1,137 @@
+package algos
+
+import (
+	"bufio"
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// EstimateChatStart finds the time that offset zero of a chat is at, from the time each message was sent
+func EstimateChatStart(comments helpers.CommentIterator) (time.Time, bool) {
+	var starts []float64
+	comments(func(comment models.Comments) {
+		if !comment.CreatedAt.IsZero() {
+			starts = append(starts, float64(comment.CreatedAt.UnixNano())/1e9-comment.ContentOffsetSeconds)
+		}
+	})
+	if len(starts) < 1 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(medianFloat(starts)*1e9)), true
+}
+
+// RebuildChatFromIrcLog re-creates the chat json of a live recording from its raw irc log
+// Each PRIVMSG and USERNOTICE is converted the same way as when recording live, with its offset relative to start
+// If start is zero, the time of the first message in the log is used
+// Like when recording live, messages sent before start are dropped, and offsets are moved past any cut ad breaks
+// Moderation events are applied to the comments, and saved into the moderation timeline if a path is given
+func RebuildChatFromIrcLog(pathIrcLog string, start time.Time, streamer models.Streamer, vodId string, saveFile string, pathModerationJson string, adBreaks []models.AdBreak) (int, error) {
+
+	// Open the log, and the temporary journal we will build the chat in
+	file, err := os.Open(pathIrcLog)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	pathJournal := saveFile + ".rebuild.jsonl"
+	_ = os.Remove(pathJournal)
+	journal, err := helpers.OpenChatJournal(pathJournal)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(pathJournal)
+
+	// Replay every line of the log
+	moderation := newModerationTracker()
+	timeline := models.ModerationTimeline{Streamer: streamer}
+	latestTime := start
+	reader := bufio.NewReader(file)
+	for {
+		line, errRead := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			var comments []models.Comments
+			message := twitchirc.ParseMessage(line)
+			switch message := message.(type) {
+			case *twitchirc.PrivateMessage:
+				comments = append(comments, ircPrivateComment(*message))
+			case *twitchirc.UserNoticeMessage:
+				comments = append(comments, ircUserNoticeComment(*message))
+			}
+			event, isEvent := ircModerationEvent(message)
+			if start.IsZero() && len(comments) > 0 {
+				start = comments[0].CreatedAt
+			} else if start.IsZero() && isEvent {
+				start = event.CreatedAt
+			}
+			// NOTE: moderation events are kept even if they were before the start (e.g. the room state when we joined)
+			if len(comments) > 0 && comments[0].CreatedAt.Before(start) {
+				comments = nil
+			}
+			for i := range comments {
+				comments[i].ContentId = vodId
+				comments[i].ContentOffsetSeconds = comments[i].CreatedAt.Sub(start).Seconds()
+				moderation.Add(comments[i])
+				if comments[i].CreatedAt.After(latestTime) {
+					latestTime = comments[i].CreatedAt
+				}
+			}
+			if isEvent {
+				event.ContentOffsetSeconds = event.CreatedAt.Sub(start).Seconds()
+				timeline.Events = append(timeline.Events, event)
+				comments = append(comments, moderation.Apply(event)...)
+			}
+			err = journal.Append(comments...)
+			if err != nil {
+				_ = journal.Close()
+				return 0, err
+			}
+		}
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			_ = journal.Close()
+			return 0, errRead
+		}
+	}
+	err = journal.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// If ad breaks were cut out of the video, everything is moved so it still lines up with it
+	var shift func(offset float64) float64
+	for _, adBreak := range adBreaks {
+		if adBreak.Cut {
+			shift = func(offset float64) float64 {
+				return adCutOffset(adBreaks, offset)
+			}
+			break
+		}
+	}
+
+	// Save the moderation timeline
+	if pathModerationJson != "" && len(timeline.Events) > 0 {
+		if shift != nil {
+			for i := range timeline.Events {
+				timeline.Events[i].ContentOffsetSeconds = shift(timeline.Events[i].ContentOffsetSeconds)
+			}
+		}
+		err = helpers.SaveModerationTimeline(pathModerationJson, timeline)
+		if err != nil {
+			log.Printf("REBUILD: unable to save %s: %s\n", pathModerationJson, err)
+		}
+	}
+
+	// Finally write the chat, keeping any emotes that were already embedded
+	emotes, _ := helpers.LoadChatEmotes(saveFile)
+	return helpers.CompactChatJournalShifted(pathJournal, saveFile, streamer, latestTime.Sub(start).Seconds(), emotes, shift)
+
+}
@@ -0,0 +1,125 @@
+package algos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/grafov/m3u8"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ffmpegDurationRegex = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+
+// ffmpegDuration will have ffmpeg probe the file and return its duration in seconds
+// NOTE: ffmpeg will exit with an error since we do not give an output, so we just parse its stderr
+func ffmpegDuration(ffmpeg string, path string) (float64, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(ffmpeg, "-hide_banner", "-i", path)
+	cmd.Stderr = &stderr
+	_ = cmd.Run()
+	match := ffmpegDurationRegex.FindStringSubmatch(stderr.String())
+	if match == nil {
+		return 0, errors.New("unable to find duration in ffmpeg output")
+	}
+	hours, _ := strconv.ParseFloat(match[1], 64)
+	minutes, _ := strconv.ParseFloat(match[2], 64)
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	return hours*3600 + minutes*60 + seconds, nil
+}
+
+// writeConcatList will write a ffmpeg concat demuxer file listing each segment in playlist order
+// https://trac.ffmpeg.org/wiki/Concatenate#demuxer
+func writeConcatList(pathList string, segments []*m3u8.MediaSegment) (float64, error) {
+	var buffer bytes.Buffer
+	totalDuration := 0.0
+	for _, segment := range segments {
+		if segment == nil {
+			continue
+		}
+		name := strings.ReplaceAll(segment.URI, "'", "'\\''")
+		buffer.WriteString("file '" + name + "'\n")
+		totalDuration += segment.Duration
+	}
+	return totalDuration, ioutil.WriteFile(pathList, buffer.Bytes(), 0644)
+}
+
+// RemuxVodSegments will combine all downloaded segments of a vod into a single video file
+// The segments in saveDir are concatenated in playlist order and copied into the container specified in the config
+// If the segments have not changed since the last remux and its output is still there, it is not remuxed again
+// The output is always verified to have the expected duration, and only then are the segments deleted (if requested)
+func RemuxVodSegments(username string, vodId string, saveDir string, segments []*m3u8.MediaSegment, changed bool, deleteSegments bool, container string, ffmpeg string) (string, error) {
+
+	// Write the concat list file next to the segments
+	pathList := filepath.Join(saveDir, "concat.txt")
+	expectedDuration, err := writeConcatList(pathList, segments)
+	if err != nil {
+		return "", err
+	}
+
+	// ffmpeg concat all the segments into a single file
+	container = strings.TrimPrefix(strings.ToLower(container), ".")
+	pathVideo := filepath.Join(filepath.Dir(saveDir), vodId+"."+container)
+	pathVideoTmp := filepath.Join(filepath.Dir(saveDir), vodId+".tmp."+container)
+	_, errExists := os.Stat(pathVideo)
+	if !changed && errExists == nil {
+		log.Printf("VIDEO: %s - segments of vod %s have not changed, skipping remux\n", username, vodId)
+		return verifyRemux(username, vodId, saveDir, segments, pathVideo, expectedDuration, deleteSegments, ffmpeg)
+	}
+	timeConversion := time.Now()
+	cmd := exec.Command(ffmpeg, "-y", "-err_detect", "ignore_err", "-f", "concat", "-safe", "0", "-i", pathList, "-c", "copy", pathVideoTmp)
+	cmd.Stdout = os.Stdout
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg start error %s", err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		_ = os.Remove(pathVideoTmp)
+		return "", fmt.Errorf("ffmpeg error %s", err)
+	}
+	err = os.Rename(pathVideoTmp, pathVideo)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("VIDEO: %s - ffmpeg remuxed vod %s in %s!\n", username, vodId, time.Since(timeConversion).String())
+	return verifyRemux(username, vodId, saveDir, segments, pathVideo, expectedDuration, deleteSegments, ffmpeg)
+
+}
+
+// verifyRemux checks the remuxed output has the length of the playlist, and then deletes the segments if requested
+func verifyRemux(username string, vodId string, saveDir string, segments []*m3u8.MediaSegment, pathVideo string, expectedDuration float64, deleteSegments bool, ffmpeg string) (string, error) {
+
+	// Check that the output has the same length as the playlist
+	// NOTE: we allow a small difference since the container and segment timestamps do not line up exactly
+	duration, err := ffmpegDuration(ffmpeg, pathVideo)
+	if err != nil {
+		return pathVideo, err
+	}
+	tolerance := math.Max(2.0, 0.005*expectedDuration)
+	if math.Abs(duration-expectedDuration) > tolerance {
+		return pathVideo, fmt.Errorf("remuxed duration %.2f does not match playlist %.2f, keeping segments", duration, expectedDuration)
+	}
+	if !deleteSegments {
+		return pathVideo, nil
+	}
+
+	// Finally delete the segments
+	for _, segment := range segments {
+		if segment == nil {
+			continue
+		}
+		_ = os.Remove(filepath.Join(saveDir, segment.URI))
+	}
+	log.Printf("VIDEO: %s - deleted segments of vod %s (%.2f sec verified)\n", username, vodId, duration)
+	return pathVideo, nil
+
+}
@@ -0,0 +1,180 @@
+package algos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// segmentTimeout is the longest a single segment download can take, so a stalled connection never blocks a worker forever
+// NOTE: segments are only a few seconds of video, so even slow connections finish well within this
+const segmentTimeout = 2 * time.Minute
+
+// segmentClient is used for all segment downloads
+var segmentClient = &http.Client{Timeout: segmentTimeout}
+
+// segmentJob is a single remote file that a download worker should fetch into saveFile
+type segmentJob struct {
+	Index    int
+	Name     string
+	Url      string
+	SaveFile string
+}
+
+// segmentResult is returned by a download worker once a job has finished (or given up)
+type segmentResult struct {
+	Index  int
+	Name   string
+	Size   int64
+	Sha256 string
+	Err    error
+}
+
+// segmentExistsOnDisk checks if a segment has already been downloaded
+// Files which exist but have no data are deleted so they will be re-downloaded
+func segmentExistsOnDisk(saveFile string) bool {
+	fi, err := os.Stat(saveFile)
+	if err == nil && fi.Size() > 0 {
+		return true
+	}
+	if err == nil && fi.Size() <= 0 {
+		log.Printf("VIDEO: deleting bad file %s", filepath.Base(saveFile))
+		_ = os.Remove(saveFile)
+	}
+	return false
+}
+
+// fileChecksum returns the size and sha256 of a file on disk
+func fileChecksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// downloadFile will fetch the url and save it to disk, returning the size and sha256 of what was written
+// NOTE: we write into a temporary file first and then rename it
+// NOTE: this way a partially downloaded segment will never pass the "has data" resume check
+func downloadFile(url string, saveFile string) (int64, string, error) {
+
+	// Download and save to file
+	resp, err := segmentClient.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("invalid response code: %d", resp.StatusCode)
+	}
+
+	// Create local file and write to it
+	saveFileTmp := saveFile + ".part"
+	out, err := os.Create(saveFileTmp)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), resp.Body)
+	errClose := out.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(saveFileTmp)
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), os.Rename(saveFileTmp, saveFile)
+
+}
+
+// downloadFileWithRetry will try to download the file multiple times with an exponential backoff
+func downloadFileWithRetry(url string, saveFile string, retries int) (int64, string, error) {
+	var err error
+	backoff := 1 * time.Second
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		size, checksum, errDownload := downloadFile(url, saveFile)
+		if errDownload == nil {
+			return size, checksum, nil
+		}
+		err = errDownload
+	}
+	return 0, "", err
+}
+
+// downloadSegments will download all jobs using a bounded pool of workers
+// Progress is reported in playlist order, so the log shows how much of the video is contiguous on disk
+// Returns the result of each job in the same order as the jobs
+func downloadSegments(username string, jobs []segmentJob, countTotalSegments int, workers int, retries int) []segmentResult {
+
+	// Nothing to do
+	if len(jobs) < 1 {
+		return []segmentResult{}
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	// Start our workers
+	jobQueue := make(chan segmentJob)
+	results := make(chan segmentResult)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for job := range jobQueue {
+				size, checksum, err := downloadFileWithRetry(job.Url, job.SaveFile, retries)
+				results <- segmentResult{Index: job.Index, Name: job.Name, Size: size, Sha256: checksum, Err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, job := range jobs {
+			jobQueue <- job
+		}
+		close(jobQueue)
+	}()
+
+	// Collect results, and report them in the order of the playlist
+	// NOTE: a worker can finish a later segment before an earlier one, so we buffer those
+	finished := make(map[int]segmentResult)
+	ordered := make([]segmentResult, 0, len(jobs))
+	nextReport := 0
+	for range jobs {
+		result := <-results
+		finished[result.Index] = result
+		for nextReport < len(jobs) {
+			result, ok := finished[jobs[nextReport].Index]
+			if !ok {
+				break
+			}
+			delete(finished, jobs[nextReport].Index)
+			if result.Err != nil {
+				log.Printf("VIDEO: %s - failed %s (%d / %d): %s", username, result.Name, result.Index, countTotalSegments, result.Err)
+			} else {
+				log.Printf("VIDEO: %s - downloaded %s (%d / %d)", username, result.Name, result.Index, countTotalSegments)
+			}
+			ordered = append(ordered, result)
+			nextReport++
+		}
+	}
+	return ordered
+
+}
@@ -1,262 +1,390 @@
 package algos
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/goldbattle/twitch_vods/models"
 	"github.com/goldbattle/twitch_vods/twitch"
 	"github.com/grafov/m3u8"
 	"github.com/nicklaw5/helix"
-	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
-func DownloadStreamLive(client *helix.Client, username string, usernameId string, config models.ConfigurationFile) {
+// hlsLiveEdge is how many segments back from the newest one we start recording at
+// NOTE: this is the same as the streamlink default, so both recorders start at the same point
+const hlsLiveEdge = 3
 
-	// Check if we have a stream that is live
-	stream, err := twitch.GetLatestStream(client, usernameId)
-	if err != nil {
-		log.Printf("LIVE: %s - error %s\n", username, err)
-		return
-	}
+// hlsOfflineTimeout is how long we wait without any new segments before deciding the recording has stalled
+// NOTE: this is only the default, live_stall_sec is used instead if it is set
+const hlsOfflineTimeout = 90 * time.Second
 
-	// Convert the stream id to the vod id that we will save into
-	vod, err := twitch.GetVodFromStreamId(client, username, usernameId, config, stream)
-	if err != nil {
-		log.Printf("LIVE: %s - error %s\n", username, err)
-		return
-	}
-	log.Printf("LIVE: %s - stream id = %s", username, stream.ID)
-	log.Printf("LIVE: %s - vod id = %s", username, vod.ID)
+// DownloadStreamLive records the live stream by downloading its HLS segments ourselves, and its chat over irc
+// This is the same as DownloadStreamLiveStreamLink, but does not need streamlink to be installed
+func DownloadStreamLive(client *helix.Client, hub *ChatHub, username string, usernameId string, downloadVideo bool, config models.ConfigurationFile) {
+	downloadStreamLive(client, hub, username, usernameId, downloadVideo, true, config)
+}
 
-	// Parse VOD date
-	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
-	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+// hlsRecorder downloads the segments of a live stream straight into a single growing MPEG-TS file
+// The signed playlist urls are refreshed when they expire, and we follow the media sequence so no segments are skipped
+// NOTE: segments after an EXT-X-DISCONTINUITY are written as-is, ffmpeg corrects the timestamp jump when remuxing
+type hlsRecorder struct {
+	mutex            sync.Mutex
+	username         string
+	qualities        []string
+	saveFile         string
+	retries          int
+	stallTimeout     time.Duration
+	logger           *log.Logger
+	stop             chan struct{}
+	stopOnce         sync.Once
+	variant          string
+	selected         models.ManifestVariant
+	uri              string
+	started          bool
+	nextSeq          uint64
+	newestSeq        uint64
+	adSeq            uint64
+	firstSegmentTime time.Time
+	Segments         int
+	Missed           int
+	Discontinuities  int
+	Refreshes        int
+	ExitReason       string
+	ads              *adBreakTracker
+	cutAds           bool
+	onAdBreak        func()
+}
 
-	// Create file / folders if needed to save into
-	saveDir := filepath.Join(config.SaveDirectory, strings.ToLower(username), yearFolder, vod.ID+"_live")
-	err = os.MkdirAll(saveDir, os.ModePerm)
-	if err != nil {
-		log.Printf("LIVE: %s - error %s", username, err)
-		return
+func newHlsRecorder(username string, qualities []string, saveFile string, retries int, logger *log.Logger) *hlsRecorder {
+	if retries < 1 {
+		retries = 1
+	}
+	return &hlsRecorder{
+		username:     username,
+		qualities:    qualities,
+		saveFile:     saveFile,
+		retries:      retries,
+		stallTimeout: hlsOfflineTimeout,
+		logger:       logger,
+		stop:         make(chan struct{}),
+		ads:          &adBreakTracker{},
 	}
+}
 
-	// Download the playlist if we do not already have it downloaded
-	saveFilePlaylist := filepath.Join(saveDir, "playlist.m4u8")
-	if _, err := os.Stat(saveFilePlaylist); err != nil {
+// Stop ends the recording, Run will return after the segment it is currently downloading
+func (recorder *hlsRecorder) Stop() {
+	recorder.stopOnce.Do(func() {
+		close(recorder.stop)
+	})
+}
 
-		// Now lets try to get the video
-		// Query twitch to get our request signature for m3u8 files
-		jsonPayload := map[string]string{
-			"query": `
-           {
-			  streamPlaybackAccessToken(channelName: "` + strings.ToLower(stream.UserName) + `", params: {platform: "web", playerBackend: "mediaplayer", playerType: "site"}) {
-				signature
-				value
-			  }
-			}
-       `,
-		}
-		body, err := twitch.CallGraphQl("https://gql.twitch.tv/gql", jsonPayload)
-		if err != nil {
-			log.Printf("LIVE: %s - error %s\n", username, err)
-			return
+// FirstSegmentTime is the EXT-X-PROGRAM-DATE-TIME of the first segment in the file
+// It is set before the file is created, so it is valid as soon as the file exists
+func (recorder *hlsRecorder) FirstSegmentTime() time.Time {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.firstSegmentTime
+}
+
+// Variant is the quality we are recording
+func (recorder *hlsRecorder) Variant() models.ManifestVariant {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.selected
+}
+
+// Run records until the stream ends or we are stopped
+// An error is only returned if we were unable to record at all, or unable to write to disk
+func (recorder *hlsRecorder) Run() error {
+
+	// Select our variant, if the stream is not live then there is nothing to do
+	recorder.ExitReason = "error"
+	err := recorder.refresh()
+	if err != nil {
+		return err
+	}
+	var out *os.File
+	defer func() {
+		if out != nil {
+			_ = out.Close()
 		}
+	}()
 
-		// Convert to the api response
-		apiResponse := models.GraphQLStreamPlaybackAccessResponse{}
-		err = json.Unmarshal(body, &apiResponse)
-		if err != nil {
-			log.Printf("LIVE: %s - error api response is bad %s\n", username, err)
-			return
+	// Poll the media playlist and append each new segment to our file
+	lastNew := time.Now()
+	for {
+		select {
+		case <-recorder.stop:
+			recorder.ExitReason = "stopped"
+			return nil
+		default:
 		}
 
-		// Call our api endpoint to get the playlist
-		baseUrl := "http://usher.twitch.tv/api/channel/hls/" + strings.ToLower(stream.UserName) + ".m3u8"
-		baseUrl += "?sig=" + apiResponse.Data.StreamPlaybackAccessToken.Signature
-		baseUrl += "&token=" + apiResponse.Data.StreamPlaybackAccessToken.Value
-		baseUrl += "&p=" + strconv.Itoa(rand.Intn(999999))
-		baseUrl += "&player=twitchweb&type=any&allow_source=true&playlist_include_framerate=true"
-		res, err := http.Get(baseUrl)
+		// If the playlist fails (e.g. our token has expired) then get new signed urls from the master playlist
+		playlist, err := twitch.GetLiveMediaPlaylist(recorder.uri)
 		if err != nil {
-			log.Printf("LIVE: %s - error %s\n", username, err)
-			return
+			recorder.logger.Printf("media playlist error %s, refreshing access token\n", err)
+			err = recorder.refresh()
+			if err == twitch.ErrNoLiveStreams {
+				log.Printf("LIVE: %s - stream has gone offline\n", recorder.username)
+				recorder.ExitReason = "ended"
+				return nil
+			}
+			if err != nil {
+				recorder.logger.Printf("refresh error %s\n", err)
+			}
+			if time.Since(lastNew) > recorder.stallTimeout {
+				log.Printf("LIVE: %s - no new segments for %s, stopping\n", recorder.username, recorder.stallTimeout)
+				recorder.ExitReason = "stalled"
+				return nil
+			}
+			recorder.wait(2 * time.Second)
+			continue
 		}
-		defer res.Body.Close()
 
-		// Finally save the playlist to file
-		body, err = ioutil.ReadAll(res.Body)
+		// Download everything we do not have yet
+		count, err := recorder.download(playlist, &out)
 		if err != nil {
-			log.Printf("LIVE: %s - error %s\n", username, err)
-			return
+			return err
 		}
-		err = ioutil.WriteFile(saveFilePlaylist, body, 0644)
-		if err != nil {
-			log.Printf("LIVE: %s - error %s\n", username, err)
-			return
+		if count > 0 {
+			lastNew = time.Now()
+		}
+		if playlist.Closed {
+			log.Printf("LIVE: %s - playlist has ended\n", recorder.username)
+			recorder.ExitReason = "ended"
+			return nil
+		}
+		if time.Since(lastNew) > recorder.stallTimeout {
+			log.Printf("LIVE: %s - no new segments for %s, stopping\n", recorder.username, recorder.stallTimeout)
+			recorder.ExitReason = "stalled"
+			return nil
+		}
+
+		// Twitch adds a new segment every target duration, so poll a bit faster than that
+		wait := time.Duration(playlist.TargetDuration * float64(time.Second) / 2)
+		if wait < time.Second {
+			wait = time.Second
 		}
+		recorder.wait(wait)
 	}
 
-	// Parse the m3u8 playlist
-	//playlist, listType, err := m3u8.DecodeFrom(res.Body, false)
-	file, err := os.Open(saveFilePlaylist)
-	if err != nil {
-		log.Printf("LIVE: %s - error %s\n", username, err)
-		return
+}
+
+// wait sleeps, returning early if we are stopped
+func (recorder *hlsRecorder) wait(duration time.Duration) {
+	select {
+	case <-recorder.stop:
+	case <-time.After(duration):
 	}
-	playlist, listType, err := m3u8.DecodeFrom(bufio.NewReader(file), false)
+}
+
+// refresh gets new signed urls for our variant
+// The first time we select from the quality preferences, after that we keep the same variant so the file does not change
+// NOTE: if our variant is no longer listed we have to select another, which is logged and kept in the variant's switched_from
+func (recorder *hlsRecorder) refresh() error {
+	master, err := twitch.GetLiveMasterPlaylist(recorder.username)
 	if err != nil {
-		log.Printf("LIVE: %s - error %s\n", username, err)
-		log.Printf("LIVE: %s - deleting bad file %s", username, saveFilePlaylist)
-		_ = os.Remove(saveFilePlaylist)
-		return
+		return err
 	}
-	if listType != m3u8.MASTER {
-		log.Printf("LIVE: %s - error playlist is not m3u8.MASTER\n", username)
-		log.Printf("LIVE: %s - deleting bad file %s", username, saveFilePlaylist)
-		_ = os.Remove(saveFilePlaylist)
-		return
+	index := -1
+	if recorder.variant != "" {
+		for idx, variant := range master.Variants {
+			if variant != nil && !variant.Iframe && variantName(variant) == recorder.variant {
+				index = idx
+				break
+			}
+		}
+		if index == -1 {
+			recorder.logger.Printf("variant %s is gone, selecting again\n", recorder.variant)
+		}
 	}
-	masterPlaylist := playlist.(*m3u8.MasterPlaylist)
-	log.Printf("LIVE: %s - found %d variants", username, len(masterPlaylist.Variants))
-	indexVideo := -1
-	cleanResolution := strings.ReplaceAll(config.VideoResolution, "p", "")
-	for idx, variant := range masterPlaylist.Variants {
-		if strings.Contains(variant.Resolution, cleanResolution) {
-			indexVideo = idx
-			break
+	if index == -1 {
+		index, err = selectVariant(master.Variants, recorder.qualities)
+		if err != nil {
+			return err
 		}
 	}
-	if indexVideo == -1 {
-		log.Printf("LIVE: %s - unable to find requested %s res in playlist\n", username, config.VideoResolution)
-		log.Printf("LIVE: %s - deleting bad file %s", username, saveFilePlaylist)
-		_ = os.Remove(saveFilePlaylist)
-		return
+	name := variantName(master.Variants[index])
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	switchedFrom := recorder.selected.SwitchedFrom
+	if recorder.variant == "" {
+		log.Printf("LIVE: %s - selected %s variant (%s)\n", recorder.username, name, master.Variants[index].Resolution)
+	} else {
+		recorder.Refreshes++
+		if name != recorder.variant {
+			log.Printf("LIVE: %s - switched from %s to %s variant (%s)\n", recorder.username, recorder.variant, name, master.Variants[index].Resolution)
+			switchedFrom = append(switchedFrom, recorder.variant)
+		}
 	}
-	//log.Printf("LIVE: %s - resolution = %s\n", username, masterPlaylist.Variants[indexVideo].Resolution)
-	//log.Printf("LIVE: %s - url = %s\n", username, masterPlaylist.Variants[indexVideo].URI)
-	masterPlaylistUri := masterPlaylist.Variants[indexVideo].URI
+	recorder.selected = manifestVariant(master.Variants[index])
+	recorder.selected.SwitchedFrom = switchedFrom
+	recorder.variant = name
+	recorder.uri = master.Variants[index].URI
+	return nil
+}
 
-	// Now lets query our master playlist for new segments
-	// NOTE: the first few segments will be amazon ads, we try to skip those...
-	// NOTE: the same playlist should be used to keep the ids the same
-	for true {
+// download appends all segments after the last one we have to the file, returning how many were new
+// Prefetch segments are downloaded right after, since they are the segments twitch will list next
+func (recorder *hlsRecorder) download(playlist *twitch.LiveMediaPlaylist, out **os.File) (int, error) {
 
-		// Call our api endpoint
-		res, err := http.Get(masterPlaylistUri)
-		if err != nil {
-			log.Printf("LIVE: %s - error %s\n", username, err)
-			return
+	// Get the valid segments (non-null)
+	var segments []*m3u8.MediaSegment
+	for _, segment := range playlist.Segments {
+		if segment != nil {
+			segments = append(segments, segment)
 		}
-		defer res.Body.Close()
+	}
+	if len(segments) < 1 {
+		return 0, nil
+	}
+	newest := segments[len(segments)-1].SeqId
 
-		// Parse the m3u8 playlist
-		playlist, listType, err = m3u8.DecodeFrom(res.Body, false)
-		if err != nil {
-			log.Printf("LIVE: %s - error %s\n", username, err)
-			return
-		}
-		if listType != m3u8.MEDIA {
-			log.Printf("LIVE: %s - error playlist is not m3u8.MEDIA\n", username)
-			return
+	// The first time we start from the live edge
+	// NOTE: if the sequence has gone backwards the stream was restarted, so we start again from the live edge
+	if recorder.started && newest < recorder.newestSeq {
+		recorder.logger.Printf("media sequence went from %d back to %d, restarting from live edge\n", recorder.newestSeq, newest)
+		recorder.Discontinuities++
+		recorder.started = false
+	}
+	if !recorder.started {
+		idx := len(segments) - hlsLiveEdge
+		if idx < 0 {
+			idx = 0
 		}
-		segmentPlaylist := playlist.(*m3u8.MediaPlaylist)
-		//log.Printf("LIVE: %s - found %d video segments", username, len(segmentPlaylist.Segments))
+		segments = segments[idx:]
+		recorder.nextSeq = segments[0].SeqId
+		recorder.adSeq = segments[0].SeqId
+		recorder.started = true
+	}
+	recorder.newestSeq = newest
 
-		// Count total valid segments (non-null)
-		countTotalSegments := 0
-		for _, segment := range segmentPlaylist.Segments {
-			if segment != nil {
-				countTotalSegments++
+	// Download new segments in order, noting any we have missed
+	// NOTE: prefetched segments were written before they were listed, so they are only checked for ads now
+	count := 0
+	for _, segment := range segments {
+		isAd := false
+		if segment.SeqId >= recorder.adSeq {
+			recorder.adSeq = segment.SeqId + 1
+			isAd = recorder.ads.Add(segment, playlist.DateRanges)
+			if isAd && recorder.onAdBreak != nil {
+				recorder.onAdBreak()
 			}
 		}
-		if countTotalSegments < 1 {
-			log.Printf("LIVE: %s - no segments to download....", username)
-			log.Printf("LIVE: %s - deleting bad file %s", username, saveFilePlaylist)
-			_ = os.Remove(saveFilePlaylist)
-			return
+		if segment.SeqId < recorder.nextSeq {
+			continue
+		}
+		if segment.SeqId > recorder.nextSeq {
+			missed := int(segment.SeqId - recorder.nextSeq)
+			log.Printf("LIVE: %s - missed %d segments before seq %d\n", recorder.username, missed, segment.SeqId)
+			recorder.Missed += missed
+		}
+		recorder.nextSeq = segment.SeqId + 1
+		count++
+		if segment.Discontinuity {
+			recorder.logger.Printf("discontinuity at seq %d (title was %s)\n", segment.SeqId, segment.Title)
+			recorder.Discontinuities++
 		}
-		//log.Printf("LIVE: %s - found %d video VALID segments", username, countTotalSegments)
-
-		// Download segments we don't already have
-		for _, segment := range segmentPlaylist.Segments {
-
-			// Skip invalid / end segments
-			if segment == nil {
-				continue
-			}
-
-			// Try to skip amazon ad segmetns
-			if strings.Contains(strings.ToLower(segment.Title), "amazon") {
-				log.Printf("LIVE: %s - skipping seg %d, detected as ad (title was %s)", username, segment.SeqId, segment.Title)
-				continue
-			}
-
-			// Debug print statments
-			//log.Println(segment)
-			log.Printf("seq id = %d\n", segment.SeqId)
-			log.Printf("\t-> title = %s", segment.Title)
-			log.Printf("\t-> date = %s", segment.ProgramDateTime)
-			//log.Printf("\t-> duration = %f", segment.Duration)
-			//log.Printf("\t-> diff with curr = %s", time.Now().UTC().Sub(segment.ProgramDateTime))
-
-			// Check the file segment on disk
-			// Also use this to check if the file exists
-			ext := filepath.Ext(segment.URI)
-			filename := strconv.FormatInt(segment.ProgramDateTime.UnixNano(), 10)
-			filename += "_" + strconv.FormatUint(segment.SeqId, 10) + ext
-			saveFile := filepath.Join(saveDir, filename)
-			fi, err := os.Stat(saveFile)
 
-			// Skip if file exists and has data
-			if err == nil && fi.Size() > 0 {
-				continue
-			}
-			if err == nil && fi.Size() <= 0 {
-				log.Printf("LIVE: deleting bad file %s", filename)
-				_ = os.Remove(saveFile)
-			}
-			log.Printf("LIVE: %s - downloading seg %d into %s", username, segment.SeqId, filename)
+		// Ads are recorded like any other segment, unless we have been asked to cut them out
+		if isAd && recorder.cutAds {
+			recorder.logger.Printf("skipping seg %d, detected as ad (title was %s)\n", segment.SeqId, segment.Title)
+			continue
+		}
+		data, err := recorder.fetch(segment.URI)
+		if err != nil {
+			log.Printf("LIVE: %s - unable to download seg %d: %s\n", recorder.username, segment.SeqId, err)
+			recorder.Missed++
+			continue
+		}
+		err = recorder.write(out, data, segment.ProgramDateTime)
+		if err != nil {
+			return count, err
+		}
+	}
 
-			// Download and save to file
-			resp, err := http.Get(segment.URI)
-			if err != nil {
-				log.Printf("LIVE: error %s", err)
-				continue
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				log.Printf("LIVE: %s - invalid response code: %d", username, resp.StatusCode)
-				continue
-			}
+	// Prefetch segments have no tags, so we only get them if the newest segment was not an ad and no ad break is coming up
+	// NOTE: if a prefetch fails we will just get it once it is listed
+	if recorder.ads.InBreak() || adBreakAhead(segments[len(segments)-1], playlist.DateRanges) {
+		return count, nil
+	}
+	for i, uri := range playlist.Prefetch {
+		seq := newest + uint64(i) + 1
+		if seq != recorder.nextSeq {
+			continue
+		}
+		data, err := recorder.fetch(uri)
+		if err != nil {
+			recorder.logger.Printf("prefetch of seg %d failed: %s\n", seq, err)
+			break
+		}
+		err = recorder.write(out, data, time.Time{})
+		if err != nil {
+			return count, err
+		}
+		recorder.nextSeq = seq + 1
+		count++
+	}
+	return count, nil
 
-			// Create local file and write to it
-			out, err := os.Create(saveFile)
-			if err != nil {
-				log.Printf("LIVE: %s - error %s", username, err)
-				continue
-			}
-			defer out.Close()
-			_, err = io.Copy(out, resp.Body)
-			if err != nil {
-				log.Printf("LIVE: %s - error %s", username, err)
-				continue
-			}
+}
 
+// write appends a segment to the file, creating it on the first segment
+func (recorder *hlsRecorder) write(out **os.File, data []byte, programDateTime time.Time) error {
+	if *out == nil {
+		recorder.mutex.Lock()
+		recorder.firstSegmentTime = programDateTime
+		if recorder.firstSegmentTime.IsZero() {
+			recorder.firstSegmentTime = time.Now()
+		}
+		recorder.mutex.Unlock()
+		file, err := os.OpenFile(recorder.saveFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
 		}
+		*out = file
+	}
+	_, err := (*out).Write(data)
+	if err != nil {
+		return err
+	}
+	recorder.Segments++
+	return nil
+}
 
-		/// Done, sleep for a bit...
-		//log.Printf("LIVE: %s - done downloading video segments!!!", username)
-		time.Sleep(15 * time.Second)
+// fetch downloads a whole segment into memory, so that a failed download never leaves half a segment in the file
+func (recorder *hlsRecorder) fetch(uri string) ([]byte, error) {
+	if base, err := url.Parse(recorder.uri); err == nil {
+		if ref, err := url.Parse(uri); err == nil {
+			uri = base.ResolveReference(ref).String()
+		}
 	}
+	err := errors.New("no attempts")
+	for attempt := 0; attempt < recorder.retries; attempt++ {
+		var data []byte
+		data, err = fetchSegment(uri)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
 
+func fetchSegment(uri string) ([]byte, error) {
+	resp, err := segmentClient.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response code: %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
 }
@@ -0,0 +1,211 @@
+package algos
+
+import (
+	"bufio"
+	"github.com/goldbattle/twitch_vods/models"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamlinkLogRegex matches a streamlink log line, e.g. "[cli][info] Opening stream: 1080p60 (hls)"
+var streamlinkLogRegex = regexp.MustCompile(`^\[([\w.]+)\]\[(\w+)\] (.*)$`)
+
+// streamlinkAdRegex matches the length of an ad break streamlink has detected, e.g. "Detected advertisement break of 30 seconds"
+var streamlinkAdRegex = regexp.MustCompile(`of ([\d.]+) seconds?`)
+
+// streamlinkEvent is a line of the streamlink output which tells us something about the recording
+type streamlinkEvent struct {
+	Type    string
+	Message string
+}
+
+// parseStreamlinkLine converts a line of the streamlink output into an event, if it is one we care about
+// Fatal errors are printed without a module (e.g. "error: No playable streams found on this URL")
+func parseStreamlinkLine(line string) (streamlinkEvent, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "error: ") {
+		return streamlinkEvent{Type: "error", Message: strings.TrimPrefix(line, "error: ")}, true
+	}
+	match := streamlinkLogRegex.FindStringSubmatch(line)
+	if match == nil {
+		return streamlinkEvent{}, false
+	}
+	level := match[2]
+	message := match[3]
+	switch {
+	case strings.HasPrefix(message, "Available streams: "):
+		return streamlinkEvent{Type: "streams", Message: strings.TrimPrefix(message, "Available streams: ")}, true
+	case strings.HasPrefix(message, "Opening stream: "):
+		return streamlinkEvent{Type: "opened", Message: strings.TrimPrefix(message, "Opening stream: ")}, true
+	case strings.HasPrefix(message, "Waiting for pre-roll ads"):
+		return streamlinkEvent{Type: "ad_preroll", Message: message}, true
+	case strings.HasPrefix(message, "Detected advertisement break"):
+		return streamlinkEvent{Type: "ad_detected", Message: message}, true
+	case strings.HasPrefix(message, "Filtering out segments"):
+		return streamlinkEvent{Type: "ad_start", Message: message}, true
+	case strings.HasPrefix(message, "Resuming stream output"):
+		return streamlinkEvent{Type: "ad_end", Message: message}, true
+	case strings.HasPrefix(message, "Stream ended"):
+		return streamlinkEvent{Type: "ended", Message: message}, true
+	case level == "error" || level == "critical":
+		return streamlinkEvent{Type: "error", Message: message}, true
+	case level == "warning":
+		return streamlinkEvent{Type: "warning", Message: message}, true
+	}
+	return streamlinkEvent{}, false
+}
+
+// streamlinkOutput writes the stdout and stderr of streamlink into its log file, and keeps track of the events in it
+// Ad breaks are taken from the events too, since they are about the exact stream streamlink is recording
+// NOTE: a break streamlink filtered out (--twitch-disable-ads) is cut, one it only detected is still in the video
+type streamlinkOutput struct {
+	mutex     sync.Mutex
+	username  string
+	writer    *io.PipeWriter
+	done      chan struct{}
+	ended     bool
+	paused    bool
+	opened    string
+	lastError string
+	adBreaks  []models.AdBreak
+	onAdBreak func()
+}
+
+// newStreamlinkOutput starts reading what streamlink writes, the returned writer should be used for both its stdout and stderr
+func newStreamlinkOutput(username string, logfile io.Writer) *streamlinkOutput {
+	reader, writer := io.Pipe()
+	output := &streamlinkOutput{username: username, writer: writer, done: make(chan struct{})}
+	go func() {
+		defer close(output.done)
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			line := scanner.Text()
+			_, _ = logfile.Write([]byte(line + "\n"))
+			event, ok := parseStreamlinkLine(line)
+			if !ok {
+				continue
+			}
+			log.Printf("LIVE: %s - streamlink %s: %s\n", username, event.Type, event.Message)
+			output.mutex.Lock()
+			isAd := output.addAdEvent(event, time.Now())
+			if event.Type == "ended" {
+				output.ended = true
+			}
+			if event.Type == "error" {
+				output.lastError = event.Message
+			}
+			if event.Type == "opened" {
+				output.opened = strings.TrimSpace(strings.Split(event.Message, "(")[0])
+			}
+			onAdBreak := output.onAdBreak
+			output.mutex.Unlock()
+			if isAd && onAdBreak != nil {
+				onAdBreak()
+			}
+		}
+		// NOTE: keep reading if a line was too long so streamlink never blocks on a full pipe
+		_, _ = io.Copy(logfile, reader)
+	}()
+	return output
+}
+
+// addAdEvent updates our ad breaks from the event, returning true if they changed
+// Must be called while holding the mutex
+func (output *streamlinkOutput) addAdEvent(event streamlinkEvent, now time.Time) bool {
+	switch event.Type {
+	case "ad_detected":
+		if output.paused {
+			return false
+		}
+		adBreak := models.AdBreak{Source: "streamlink", Start: now}
+		if match := streamlinkAdRegex.FindStringSubmatch(event.Message); match != nil {
+			adBreak.DurationSeconds, _ = strconv.ParseFloat(match[1], 64)
+		}
+		output.adBreaks = append(output.adBreaks, adBreak)
+		return true
+	case "ad_start", "ad_preroll":
+		if output.paused {
+			return false
+		}
+		output.paused = true
+
+		// If we just detected this break, it is now being filtered out instead
+		if len(output.adBreaks) > 0 {
+			last := &output.adBreaks[len(output.adBreaks)-1]
+			end := last.Start.Add(time.Duration(last.DurationSeconds * float64(time.Second)))
+			if !last.Cut && now.Before(end) {
+				last.Start = now
+				last.DurationSeconds = 0
+				last.Cut = true
+				return true
+			}
+		}
+		output.adBreaks = append(output.adBreaks, models.AdBreak{Source: "streamlink", Start: now, Cut: true})
+		return true
+	case "ad_end", "opened":
+		if !output.paused {
+			return false
+		}
+		output.paused = false
+		if len(output.adBreaks) > 0 && output.adBreaks[len(output.adBreaks)-1].Cut {
+			last := &output.adBreaks[len(output.adBreaks)-1]
+			last.DurationSeconds = now.Sub(last.Start).Seconds()
+		}
+		return true
+	}
+	return false
+}
+
+// AdBreaks returns the ad breaks streamlink told us about after the reference time (the first frame of the recording)
+// If streamlink is still filtering out a break, it is counted up to now
+func (output *streamlinkOutput) AdBreaks(reference time.Time) []models.AdBreak {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	breaks := append([]models.AdBreak(nil), output.adBreaks...)
+	if output.paused && len(breaks) > 0 && breaks[len(breaks)-1].Cut {
+		breaks[len(breaks)-1].DurationSeconds = time.Since(breaks[len(breaks)-1].Start).Seconds()
+	}
+	return adBreaksFrom(breaks, reference)
+}
+
+// Close waits for everything streamlink wrote to be processed, it should be called once streamlink has exited
+func (output *streamlinkOutput) Close() {
+	_ = output.writer.Close()
+	<-output.done
+}
+
+// OpenedVariant is the name of the quality streamlink selected (e.g. 1080p60), empty if it never opened a stream
+func (output *streamlinkOutput) OpenedVariant() string {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	return output.opened
+}
+
+// Paused returns true while streamlink is holding back the output for an ad break
+func (output *streamlinkOutput) Paused() bool {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	return output.paused
+}
+
+// exitReason describes why streamlink stopped, using its exit error and the events we saw
+func (output *streamlinkOutput) exitReason(err error, stopped bool) (string, string) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	switch {
+	case stopped:
+		return "stopped", ""
+	case output.ended:
+		return "ended", ""
+	case output.lastError != "":
+		return "error", output.lastError
+	case err != nil:
+		return "error", err.Error()
+	}
+	return "ended", ""
+}
@@ -1,14 +1,16 @@
 package algos
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/goldbattle/twitch_vods/helpers"
 	"github.com/goldbattle/twitch_vods/models"
 	"github.com/goldbattle/twitch_vods/twitch"
 	"github.com/grafov/m3u8"
 	"github.com/nicklaw5/helix"
-	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -50,6 +52,168 @@ func DownloadVod(client *helix.Client, username string, usernameId string, confi
 		return
 	}
 
+	// Get the media playlist of the quality we want
+	variant, bodyPlaylist, segmentPlaylist, err := getVodPlaylist(username, vod, config)
+	if err != nil {
+		log.Printf("VIDEO: %s - error %s\n", username, err)
+		return
+	}
+	masterPlaylistUri := variant.URI
+
+	// Parse VOD date
+	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
+	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+
+	// Create file / folders if needed to save into
+	saveDir := filepath.Join(config.SaveDirectory, strings.ToLower(username), yearFolder, vod.ID)
+	err = os.MkdirAll(saveDir, os.ModePerm)
+	if err != nil {
+		log.Printf("VIDEO: %s - error %s", username, err)
+		return
+	}
+
+	// Count total valid segments (non-null)
+	countTotalSegments := 0
+	for _, segment := range segmentPlaylist.Segments {
+		if segment != nil {
+			countTotalSegments++
+		}
+	}
+	if countTotalSegments < 1 {
+		log.Printf("VIDEO: %s - no segments to download....", username)
+		return
+	}
+	log.Printf("VIDEO: %s - found %d video VALID segments", username, countTotalSegments)
+
+	// Load our previous manifest (if we have one) so we can resume from it
+	// NOTE: the playlist of a recent vod can still grow, so we rebuild the segment list each time
+	manifest, _ := helpers.LoadVodManifest(config.SaveDirectory, username, vod)
+	previousList := manifest.Segments
+	previousSegments := make(map[string]models.ManifestSegment)
+	for _, segment := range manifest.Segments {
+		previousSegments[segment.Uri] = segment
+	}
+	manifest.VodId = vod.ID
+	manifest.Status = models.ManifestStatusPending
+	manifest.Playlist = string(bodyPlaylist)
+	manifest.Variant = manifestVariant(variant)
+	manifest.Segments = make([]models.ManifestSegment, 0, countTotalSegments)
+
+	// Build the list of segments we don't already have
+	var jobs []segmentJob
+	manifestIndex := make(map[int]int)
+	for idx, segment := range segmentPlaylist.Segments {
+
+		// Skip invalid / end segments
+		if segment == nil {
+			continue
+		}
+		entry, ok := previousSegments[segment.URI]
+		if !ok {
+			entry = models.ManifestSegment{Uri: segment.URI}
+		}
+		entry.Duration = segment.Duration
+		manifestIndex[idx] = len(manifest.Segments)
+		manifest.Segments = append(manifest.Segments, entry)
+
+		// Skip if file exists and has the data our manifest expects
+		// Segments without a recorded size are from an older download, so we just record their checksum
+		saveFile := filepath.Join(saveDir, segment.URI)
+		if segmentExistsOnDisk(saveFile) {
+			fi, _ := os.Stat(saveFile)
+			if entry.Size == fi.Size() && entry.Sha256 != "" {
+				continue
+			}
+			if entry.Size == 0 || entry.Sha256 == "" {
+				size, checksum, err := fileChecksum(saveFile)
+				if err == nil {
+					manifest.Segments[manifestIndex[idx]].Size = size
+					manifest.Segments[manifestIndex[idx]].Sha256 = checksum
+					continue
+				}
+			}
+			log.Printf("VIDEO: %s - segment %s does not match manifest, re-downloading", username, segment.URI)
+			_ = os.Remove(saveFile)
+		}
+		segmentRemoteUri := masterPlaylistUri[0:strings.LastIndex(masterPlaylistUri, "/")] + "/" + segment.URI
+		jobs = append(jobs, segmentJob{Index: idx, Name: segment.URI, Url: segmentRemoteUri, SaveFile: saveFile})
+
+	}
+	if len(jobs) < countTotalSegments {
+		manifest.Status = models.ManifestStatusPartial
+	}
+	manifest.SegmentsDeleted = false
+	err = helpers.SaveVodManifest(config.SaveDirectory, username, vod, manifest)
+	if err != nil {
+		log.Printf("VIDEO: %s - error saving manifest %s", username, err)
+	}
+	log.Printf("VIDEO: %s - need to download %d segments (%d workers)", username, len(jobs), config.DownloadWorkers)
+
+	// Download them in parallel and record them into our manifest
+	countFailed := 0
+	results := downloadSegments(username, jobs, countTotalSegments, config.DownloadWorkers, config.DownloadRetries)
+	for _, result := range results {
+		if result.Err != nil {
+			countFailed++
+			continue
+		}
+		manifest.Segments[manifestIndex[result.Index]].Size = result.Size
+		manifest.Segments[manifestIndex[result.Index]].Sha256 = result.Sha256
+	}
+	if countFailed > 0 {
+		log.Printf("VIDEO: %s - failed to download %d segments, will retry next time", username, countFailed)
+		manifest.Status = models.ManifestStatusFailed
+		_ = helpers.SaveVodManifest(config.SaveDirectory, username, vod, manifest)
+		return
+	}
+	manifest.Status = models.ManifestStatusComplete
+
+	/// Done :)
+	log.Printf("VIDEO: %s - done downloading video segments!!!", username)
+
+	// Remux all segments into a single video file if requested
+	// NOTE: we only delete the segments once the vod is old enough that it will not be updated again
+	if config.VodContainer != "" {
+		deleteSegments := config.VodDeleteSegments && int(diff.Minutes()) > config.SkipIfOlderMin
+		changed := len(jobs) > 0 || !sameManifestSegments(previousList, manifest.Segments)
+		pathVideo, err := RemuxVodSegments(username, vod.ID, saveDir, segmentPlaylist.Segments, changed, deleteSegments, config.VodContainer, config.Ffmpeg)
+		if err != nil {
+			log.Printf("VIDEO: %s - remux error %s", username, err)
+		}
+		if pathVideo == "" {
+			manifest.Status = models.ManifestStatusPartial
+		} else if err == nil {
+			log.Printf("VIDEO: %s - saved %s", username, pathVideo)
+			manifest.SegmentsDeleted = deleteSegments
+		}
+		manifest.Output = pathVideo
+	}
+
+	// Finally record that this vod has been completed
+	err = helpers.SaveVodManifest(config.SaveDirectory, username, vod, manifest)
+	if err != nil {
+		log.Printf("VIDEO: %s - error saving manifest %s", username, err)
+	}
+
+}
+
+// sameManifestSegments returns true if both lists have the same segments with the same data
+func sameManifestSegments(a []models.ManifestSegment, b []models.ManifestSegment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Uri != b[i].Uri || a[i].Size != b[i].Size || a[i].Sha256 != b[i].Sha256 {
+			return false
+		}
+	}
+	return true
+}
+
+// getVodPlaylist will request the master playlist of a vod and select the quality we want from it
+// Returns the selected variant along with the raw and parsed media playlist of its segments
+func getVodPlaylist(username string, vod helix.Video, config models.ConfigurationFile) (*m3u8.Variant, []byte, *m3u8.MediaPlaylist, error) {
+
 	// Query twitch to get our request signature for m3u8 files
 	jsonPayload := map[string]string{
 		"query": `
@@ -63,16 +227,14 @@ func DownloadVod(client *helix.Client, username string, usernameId string, confi
 	}
 	body, err := twitch.CallGraphQl("https://gql.twitch.tv/gql", jsonPayload)
 	if err != nil {
-		log.Printf("VIDEO: %s - error %s\n", username, err)
-		return
+		return nil, nil, nil, err
 	}
 
 	// Convert to the api response
 	apiResponse := models.GraphQLVideoPlaybackAccessResponse{}
 	err = json.Unmarshal(body, &apiResponse)
 	if err != nil {
-		log.Printf("VIDEO: %s - error api response is bad %s\n", username, err)
-		return
+		return nil, nil, nil, fmt.Errorf("api response is bad %s", err)
 	}
 
 	// Call our api endpoint
@@ -82,137 +244,48 @@ func DownloadVod(client *helix.Client, username string, usernameId string, confi
 	baseUrl += "&allow_source=true&player=twitchweb"
 	res, err := http.Get(baseUrl)
 	if err != nil {
-		log.Printf("VIDEO: %s - error %s\n", username, err)
-		return
+		return nil, nil, nil, err
 	}
 	defer res.Body.Close()
 
 	// Parse the m3u8 playlist
 	playlist, listType, err := m3u8.DecodeFrom(res.Body, false)
 	if err != nil {
-		log.Printf("VIDEO: %s - error %s\n", username, err)
-		return
+		return nil, nil, nil, err
 	}
 	if listType != m3u8.MASTER {
-		log.Printf("VIDEO: %s - error playlist is not m3u8.MASTER\n", username)
-		return
+		return nil, nil, nil, errors.New("playlist is not m3u8.MASTER")
 	}
 	masterPlaylist := playlist.(*m3u8.MasterPlaylist)
 	log.Printf("VIDEO: %s - found %d variants", username, len(masterPlaylist.Variants))
-	indexVideo := -1
-	cleanResolution := strings.ReplaceAll(config.VideoResolution, "p", "")
-	for idx, variant := range masterPlaylist.Variants {
-		if strings.Contains(variant.Resolution, cleanResolution) {
-			indexVideo = idx
-			break
-		}
-	}
-	if indexVideo == -1 {
-		log.Printf("VIDEO: %s - unable to find requested %s res in vod playlist\n", username, config.VideoResolution)
-		return
+	indexVideo, err := selectVariant(masterPlaylist.Variants, videoQualities(config))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to select quality in vod playlist: %s", err)
 	}
-	//log.Printf("VIDEO: %s - resolution = %s\n", username, masterPlaylist.Variants[indexVideo].Resolution)
-	//log.Printf("VIDEO: %s - url = %s\n", username, masterPlaylist.Variants[indexVideo].URI)
-	masterPlaylistUri := masterPlaylist.Variants[indexVideo].URI
+	variant := masterPlaylist.Variants[indexVideo]
+	log.Printf("VIDEO: %s - selected %s variant (%s)\n", username, variantName(variant), variant.Resolution)
 
 	// Call our api endpoint
-	res, err = http.Get(masterPlaylistUri)
+	res, err = http.Get(variant.URI)
 	if err != nil {
-		log.Printf("VIDEO: %s - error %s\n", username, err)
-		return
+		return nil, nil, nil, err
 	}
 	defer res.Body.Close()
+	bodyPlaylist, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	// Parse the m3u8 playlist
-	playlist, listType, err = m3u8.DecodeFrom(res.Body, false)
+	playlist, listType, err = m3u8.DecodeFrom(bytes.NewReader(bodyPlaylist), false)
 	if err != nil {
-		log.Printf("VIDEO: %s - error %s\n", username, err)
-		return
+		return nil, nil, nil, err
 	}
 	if listType != m3u8.MEDIA {
-		log.Printf("VIDEO: %s - error playlist is not m3u8.MEDIA\n", username)
-		return
+		return nil, nil, nil, errors.New("playlist is not m3u8.MEDIA")
 	}
 	segmentPlaylist := playlist.(*m3u8.MediaPlaylist)
 	log.Printf("VIDEO: %s - found %d video segments", username, len(segmentPlaylist.Segments))
-
-	// Parse VOD date
-	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
-	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
-
-	// Create file / folders if needed to save into
-	saveDir := filepath.Join(config.SaveDirectory, strings.ToLower(username), yearFolder, vod.ID)
-	err = os.MkdirAll(saveDir, os.ModePerm)
-	if err != nil {
-		log.Printf("VIDEO: %s - error %s", username, err)
-		return
-	}
-
-	// Count total valid segments (non-null)
-	countTotalSegments := 0
-	for _, segment := range segmentPlaylist.Segments {
-		if segment != nil {
-			countTotalSegments++
-		}
-	}
-	if countTotalSegments < 1 {
-		log.Printf("VIDEO: %s - no segments to download....", username)
-		return
-	}
-	log.Printf("VIDEO: %s - found %d video VALID segments", username, countTotalSegments)
-
-	// Download segments we don't already have
-	for idx, segment := range segmentPlaylist.Segments {
-
-		// Skip invalid / end segments
-		if segment == nil {
-			continue
-		}
-
-		// Check the file segment on disk
-		// Also use this to check if the file exists
-		saveFile := filepath.Join(saveDir, segment.URI)
-		fi, err := os.Stat(saveFile)
-
-		// Skip if file exists and has data
-		if err == nil && fi.Size() > 0 {
-			continue
-		}
-		if err == nil && fi.Size() <= 0 {
-			log.Printf("VIDEO: deleting bad file %s", segment.URI)
-			_ = os.Remove(saveFile)
-		}
-		log.Printf("VIDEO: %s - downloading %s (%d / %d)", username, segment.URI, idx, countTotalSegments)
-
-		// Download and save to file
-		segmentRemoteUri := masterPlaylistUri[0:strings.LastIndex(masterPlaylistUri, "/")] + "/" + segment.URI
-		resp, err := http.Get(segmentRemoteUri)
-		if err != nil {
-			log.Printf("VIDEO: error %s", err)
-			continue
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("VIDEO: %s - invalid response code: %d", username, resp.StatusCode)
-			continue
-		}
-
-		// Create local file and write to it
-		out, err := os.Create(saveFile)
-		if err != nil {
-			log.Printf("VIDEO: %s - error %s", username, err)
-			continue
-		}
-		defer out.Close()
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			log.Printf("VIDEO: %s - error %s", username, err)
-			continue
-		}
-
-	}
-
-	/// Done :)
-	log.Printf("VIDEO: %s - done downloading video segments!!!", username)
+	return variant, bodyPlaylist, segmentPlaylist, nil
 
 }
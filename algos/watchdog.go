@@ -0,0 +1,86 @@
+package algos
+
+import (
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/nicklaw5/helix"
+	"log"
+	"os"
+	"time"
+)
+
+// watchRecording calls stall once the video file has not grown for the interval while the channel is still live
+// Time where paused returns true (e.g. streamlink is holding back an ad break) does not count towards the interval
+// It returns when done is closed, or right after it has called stall
+func watchRecording(client *helix.Client, username string, usernameId string, path string, interval time.Duration, paused func() bool, done chan struct{}, stall func()) {
+	check := interval / 4
+	if check > 5*time.Second {
+		check = 5 * time.Second
+	}
+	if check < time.Second {
+		check = time.Second
+	}
+	lastSize := int64(-1)
+	lastGrowth := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(check):
+		}
+
+		// Any new bytes (or a pause) resets our timer
+		size := int64(0)
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+		if size != lastSize || paused() {
+			lastSize = size
+			lastGrowth = time.Now()
+			continue
+		}
+		if time.Since(lastGrowth) < interval {
+			continue
+		}
+
+		// If the channel has gone offline then the recorder should exit by itself
+		_, err := twitch.GetLatestStream(client, usernameId)
+		if err != nil {
+			lastGrowth = time.Now()
+			continue
+		}
+		log.Printf("LIVE: %s - no new video for %s while still live, restarting\n", username, time.Since(lastGrowth).Round(time.Second))
+		stall()
+		return
+	}
+}
+
+// watchPartLimits calls split once the video file is longer than maxDuration or bigger than maxSize (zero is no limit)
+// The duration is counted from when the file was created
+// It returns when done is closed, or right after it has called split
+func watchPartLimits(username string, path string, maxDuration time.Duration, maxSize int64, done chan struct{}, split func()) {
+	createdAt := time.Time{}
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(5 * time.Second):
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		if maxDuration > 0 && time.Since(createdAt) >= maxDuration {
+			log.Printf("LIVE: %s - part is %s long, splitting\n", username, time.Since(createdAt).Round(time.Second))
+			split()
+			return
+		}
+		if maxSize > 0 && fi.Size() >= maxSize {
+			log.Printf("LIVE: %s - part is %d MB, splitting\n", username, fi.Size()/(1024*1024))
+			split()
+			return
+		}
+	}
+}
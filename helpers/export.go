@@ -0,0 +1,196 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"github.com/goldbattle/twitch_vods/models"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportChatToText writes one "[hh:mm:ss] name: message" line per comment
+func ExportChatToText(comments []models.Comments, saveFile string) error {
+	file, err := os.Create(saveFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	for _, comment := range comments {
+		line := "[" + FormatTimestamp(comment.ContentOffsetSeconds) + "] " + commentDisplayName(comment) + ": "
+		line += strings.ReplaceAll(comment.Message.Body, "\n", " ") + "\n"
+		_, err = writer.WriteString(line)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// ExportChatToCsv writes a spreadsheet friendly table of the comments
+func ExportChatToCsv(comments []models.Comments, saveFile string) error {
+	file, err := os.Create(saveFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{"offset", "timestamp", "user_id", "login", "badges", "bits", "message"})
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		var badges []string
+		for _, badge := range comment.Message.UserBadges {
+			badges = append(badges, badge.Id+"/"+badge.Version)
+		}
+		err = writer.Write([]string{
+			strconv.FormatFloat(comment.ContentOffsetSeconds, 'f', 3, 64),
+			comment.CreatedAt.UTC().Format(time.RFC3339),
+			comment.Commenter.Id,
+			comment.Commenter.Name,
+			strings.Join(badges, ";"),
+			strconv.Itoa(comment.Message.BitsSpent),
+			comment.Message.Body,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// htmlPart is either plain text or an emote image of a message
+type htmlPart struct {
+	Text  string
+	Image template.URL
+}
+
+type htmlComment struct {
+	Time  string
+	Name  string
+	Color template.CSS
+	Parts []htmlPart
+}
+
+var htmlTemplate = template.Must(template.New("chat").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { background: #18181b; color: #efeff1; font-family: sans-serif; font-size: 14px; margin: 0; }
+#search { position: sticky; top: 0; width: 100%; box-sizing: border-box; padding: 10px; font-size: 16px; border: 0; background: #26262c; color: #efeff1; }
+.msg { padding: 2px 10px; line-height: 28px; }
+.msg:hover { background: #26262c; }
+.time { color: #adadb8; font-family: monospace; margin-right: 6px; }
+.name { font-weight: bold; }
+.msg img { height: 28px; vertical-align: middle; }
+</style>
+</head>
+<body>
+<input id="search" type="text" placeholder="Search {{len .Comments}} messages by name or text...">
+<div id="chat">
+{{range .Comments}}<div class="msg"><span class="time">{{.Time}}</span><span class="name" style="color: {{.Color}}">{{.Name}}</span>: {{range .Parts}}{{if .Image}}<img src="{{.Image}}" alt="{{.Text}}" title="{{.Text}}">{{else}}{{.Text}}{{end}}{{end}}</div>
+{{end}}</div>
+<script>
+var rows = document.getElementsByClassName("msg");
+document.getElementById("search").addEventListener("input", function (e) {
+  var query = e.target.value.toLowerCase();
+  for (var i = 0; i < rows.length; i++) {
+    var text = rows[i].textContent.toLowerCase();
+    var imgs = rows[i].getElementsByTagName("img");
+    for (var j = 0; j < imgs.length; j++) { text += " " + imgs[j].alt.toLowerCase(); }
+    rows[i].style.display = text.indexOf(query) === -1 ? "none" : "";
+  }
+});
+</script>
+</body>
+</html>
+`))
+
+// emoteDataUri converts base64 image data into a data uri we can use as an image source
+func emoteDataUri(data string) template.URL {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:" + http.DetectContentType(raw) + ";base64," + data)
+}
+
+// ExportChatToHtml writes a single self-contained page of the chat
+// Emotes are shown inline from the embedded emote data, and the page has a search box to filter messages
+func ExportChatToHtml(data models.ChatRenderStructure, saveFile string) error {
+
+	// Lookup tables for our embedded emotes
+	firstparty := make(map[string]template.URL)
+	for _, emote := range data.Emotes.Firstparty {
+		firstparty[emote.ID] = emoteDataUri(emote.Data)
+	}
+	thirdparty := make(map[string]template.URL)
+	for _, emote := range data.Emotes.Thirdparty {
+		thirdparty[emote.Name] = emoteDataUri(emote.Data)
+	}
+
+	// Convert each comment into text and image parts
+	var comments []htmlComment
+	for _, comment := range data.Comments {
+		tmp := htmlComment{}
+		tmp.Time = FormatTimestamp(comment.ContentOffsetSeconds)
+		tmp.Name = commentDisplayName(comment)
+		tmp.Color = "#9147ff"
+		if comment.Message.UserColor != nil && strings.HasPrefix(*comment.Message.UserColor, "#") {
+			if _, err := strconv.ParseUint(strings.TrimPrefix(*comment.Message.UserColor, "#"), 16, 32); err == nil {
+				tmp.Color = template.CSS(*comment.Message.UserColor)
+			}
+		}
+		fragments := comment.Message.Fragments
+		if len(fragments) < 1 {
+			fragments = []models.Fragment{{Text: comment.Message.Body}}
+		}
+		for _, fragment := range fragments {
+			if fragment.Emoticon != nil && firstparty[fragment.Emoticon.EmoticonId] != "" {
+				tmp.Parts = append(tmp.Parts, htmlPart{Text: fragment.Text, Image: firstparty[fragment.Emoticon.EmoticonId]})
+				continue
+			}
+			words := strings.Split(fragment.Text, " ")
+			for i, word := range words {
+				if i > 0 {
+					tmp.Parts = append(tmp.Parts, htmlPart{Text: " "})
+				}
+				if image, ok := thirdparty[word]; ok && image != "" {
+					tmp.Parts = append(tmp.Parts, htmlPart{Text: word, Image: image})
+				} else {
+					tmp.Parts = append(tmp.Parts, htmlPart{Text: word})
+				}
+			}
+		}
+		comments = append(comments, tmp)
+	}
+
+	// Finally write it to file
+	file, err := os.Create(saveFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	err = htmlTemplate.Execute(writer, struct {
+		Title    string
+		Comments []htmlComment
+	}{
+		Title:    data.Streamer.Name + " chat",
+		Comments: comments,
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+
+}
@@ -0,0 +1,313 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/nicklaw5/helix"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChatJournal is an append-only file of comments, one json object per line
+// Each comment is written to disk as soon as it is received, so we never need to keep a whole chat in memory
+// The journal can then be compacted into the TwitchDownloader json format at any time
+type ChatJournal struct {
+	mutex sync.Mutex
+	file  *os.File
+	Path  string
+}
+
+func GetChatJournalPath(folder string, username string, vod helix.Video) string {
+
+	// Parse VOD date
+	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
+	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+
+	// The journal lives next to the chat json
+	return filepath.Join(folder, strings.ToLower(username), yearFolder, vod.ID+"_chat.jsonl")
+
+}
+
+// OpenChatJournal opens (or creates) a journal to append comments to
+func OpenChatJournal(path string) (*ChatJournal, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// If we crashed half way through a line, end it so the next comment is not appended onto it
+	fi, err := file.Stat()
+	if err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		_, err = file.ReadAt(last, fi.Size()-1)
+		if err == nil && last[0] != '\n' {
+			_, _ = file.Write([]byte("\n"))
+		}
+	}
+	return &ChatJournal{file: file, Path: path}, nil
+}
+
+// Append writes the comments to the end of the journal
+func (journal *ChatJournal) Append(comments ...models.Comments) error {
+	var buffer []byte
+	for _, comment := range comments {
+		line, err := json.Marshal(comment)
+		if err != nil {
+			return err
+		}
+		buffer = append(buffer, line...)
+		buffer = append(buffer, '\n')
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	_, err := journal.file.Write(buffer)
+	return err
+}
+
+func (journal *ChatJournal) Close() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.file.Close()
+}
+
+// CommentIterator calls fn for every comment of a chat, so callers do not need to know where they are stored
+type CommentIterator func(fn func(comment models.Comments))
+
+// IterateComments returns an iterator over comments already in memory
+func IterateComments(comments []models.Comments) CommentIterator {
+	return func(fn func(comment models.Comments)) {
+		for _, comment := range comments {
+			fn(comment)
+		}
+	}
+}
+
+// IterateChatJournal returns an iterator which reads the comments from a journal on disk
+func IterateChatJournal(path string) CommentIterator {
+	return func(fn func(comment models.Comments)) {
+		_ = ScanChatJournal(path, fn)
+	}
+}
+
+// ScanChatJournal calls fn for every comment in the journal, in the order they were written
+// Lines which can not be parsed (e.g. the last line after a crash) are skipped
+func ScanChatJournal(path string, fn func(comment models.Comments)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			comment := models.Comments{}
+			if json.Unmarshal(line, &comment) == nil {
+				fn(comment)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// LoadChatEmotes returns only the embedded emotes of a chat json, without keeping its comments
+func LoadChatEmotes(saveFile string) (models.Emotes, error) {
+	_, emotes, err := LoadChatHeader(saveFile)
+	return emotes, err
+}
+
+// LoadChatHeader returns the streamer and embedded emotes of a chat json
+// The file is decoded as a stream and each comment is skipped over, so a long chat is never loaded into memory
+func LoadChatHeader(saveFile string) (models.Streamer, models.Emotes, error) {
+	streamer := models.Streamer{}
+	emotes := models.Emotes{}
+	file, err := os.Open(saveFile)
+	if err != nil {
+		return streamer, emotes, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	if _, err = decoder.Token(); err != nil {
+		return streamer, emotes, err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return streamer, emotes, err
+		}
+		switch token {
+		case "streamer":
+			err = decoder.Decode(&streamer)
+		case "emotes":
+			err = decoder.Decode(&emotes)
+		case "comments":
+			err = skipJsonArray(decoder)
+		default:
+			var skip json.RawMessage
+			err = decoder.Decode(&skip)
+		}
+		if err != nil {
+			return streamer, emotes, err
+		}
+	}
+	return streamer, emotes, nil
+}
+
+// skipJsonArray reads past an array one element at a time
+func skipJsonArray(decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected array, got %v", token)
+	}
+	for decoder.More() {
+		var skip json.RawMessage
+		err = decoder.Decode(&skip)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// journalEntry is where a comment is in the journal file
+type journalEntry struct {
+	Offset   float64
+	Position int64
+	Length   int
+}
+
+// CompactChatJournal writes the journal into the TwitchDownloader chat json format
+// Only the offset and file position of each comment is kept in memory so we can sort them
+// If a comment id is in the journal more than once, the last one written is used (so updated comments can just be appended)
+// If videoEnd is zero, the offset of the last comment is used as the end of the video
+func CompactChatJournal(journalPath string, saveFile string, streamer models.Streamer, videoEnd float64, emotes models.Emotes) (int, error) {
+	return CompactChatJournalShifted(journalPath, saveFile, streamer, videoEnd, emotes, nil)
+}
+
+// CompactChatJournalShifted is the same as CompactChatJournal, but moves every offset (and the video end) with shift
+// The shift must never change the order of two offsets (e.g. removing cut ad breaks from the video)
+func CompactChatJournalShifted(journalPath string, saveFile string, streamer models.Streamer, videoEnd float64, emotes models.Emotes, shift func(offset float64) float64) (int, error) {
+
+	// Index where each comment is in the journal
+	file, err := os.Open(journalPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var entries []journalEntry
+	seenIds := make(map[string]int)
+	latestCommentOffset := 0.0
+	reader := bufio.NewReader(file)
+	position := int64(0)
+	for {
+		line, errRead := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			comment := struct {
+				Id                   string  `json:"_id"`
+				ContentOffsetSeconds float64 `json:"content_offset_seconds"`
+			}{}
+			if json.Unmarshal(line, &comment) == nil {
+				entry := journalEntry{Offset: comment.ContentOffsetSeconds, Position: position, Length: len(line)}
+				if idx, ok := seenIds[comment.Id]; ok && comment.Id != "" {
+					entries[idx] = entry
+				} else {
+					seenIds[comment.Id] = len(entries)
+					entries = append(entries, entry)
+				}
+				latestCommentOffset = math.Max(latestCommentOffset, comment.ContentOffsetSeconds)
+			}
+			position += int64(len(line))
+		}
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			return 0, errRead
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	// Create data structure to match the twichdownload chat render
+	// https://github.com/lay295/TwitchDownloader/blob/master/TwitchDownloaderCore/ChatDownloader.cs#L77
+	// NOTE: we write into a temp file and move it, so the old chat is never lost half way through
+	if videoEnd <= 0 {
+		videoEnd = latestCommentOffset
+	}
+	if shift != nil {
+		videoEnd = shift(videoEnd)
+	}
+	if emotes.Firstparty == nil {
+		emotes.Firstparty = make([]models.Firstparty, 0)
+	}
+	if emotes.Thirdparty == nil {
+		emotes.Thirdparty = make([]models.Thirdparty, 0)
+	}
+	if emotes.TwitchBadges == nil {
+		emotes.TwitchBadges = make([]models.TwitchBadge, 0)
+	}
+	out, err := os.Create(saveFile + ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(out)
+	header, _ := json.Marshal(streamer)
+	_, _ = writer.WriteString("{\"streamer\":" + string(header) + ",\"comments\":[")
+	for idx, entry := range entries {
+		line := make([]byte, entry.Length)
+		_, err = file.ReadAt(line, entry.Position)
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(saveFile + ".tmp")
+			return 0, err
+		}
+		if shift != nil {
+			comment := models.Comments{}
+			if json.Unmarshal(line, &comment) == nil {
+				comment.ContentOffsetSeconds = shift(comment.ContentOffsetSeconds)
+				line, _ = json.Marshal(comment)
+			}
+		}
+		if idx > 0 {
+			_ = writer.WriteByte(',')
+		}
+		_, _ = writer.Write([]byte(strings.TrimSpace(string(line))))
+	}
+	video, _ := json.Marshal(models.Video{Start: 0, End: videoEnd})
+	footer, _ := json.Marshal(emotes)
+	_, _ = writer.WriteString("],\"video\":" + string(video) + ",\"emotes\":" + string(footer) + "}")
+	err = writer.Flush()
+	errClose := out.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(saveFile + ".tmp")
+		return 0, err
+	}
+	return len(entries), os.Rename(saveFile+".tmp", saveFile)
+
+}
@@ -0,0 +1,123 @@
+package helpers
+
+import (
+	"github.com/goldbattle/twitch_vods/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testStreamer = models.Streamer{Name: "someone", ID: 1234}
+
+// writeTestJournal appends the comments to a new journal, returning its path and where to save the chat
+func writeTestJournal(t *testing.T, comments ...models.Comments) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "chat.jsonl")
+	journal, err := OpenChatJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.Append(comments...); err != nil {
+		t.Fatal(err)
+	}
+	_ = journal.Close()
+	return journalPath, filepath.Join(dir, "chat.json")
+}
+
+// commentIds joins the ids of the comments in the saved chat, in order
+func commentIds(t *testing.T, saveFile string) (string, models.ChatRenderStructure) {
+	t.Helper()
+	data, err := LoadChatFile(saveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ""
+	for _, comment := range data.Comments {
+		ids += comment.Id
+	}
+	return ids, data
+}
+
+func TestCompactChatJournal(t *testing.T) {
+	old := models.Comments{Id: "a", ContentOffsetSeconds: 10}
+	old.Message.Body = "old"
+	edited := old
+	edited.Message.Body = "new"
+	journalPath, saveFile := writeTestJournal(t, models.Comments{Id: "b", ContentOffsetSeconds: 20}, old,
+		models.Comments{Id: "c", ContentOffsetSeconds: 30}, edited)
+
+	// Comments are sorted by offset and the last line of each id wins
+	count, err := CompactChatJournal(journalPath, saveFile, testStreamer, 100, models.Emotes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, data := commentIds(t, saveFile)
+	if count != 3 || ids != "abc" {
+		t.Errorf("got %d comments %s, want abc", count, ids)
+	}
+	if data.Comments[0].Message.Body != "new" {
+		t.Errorf("got body %s, want the edited one", data.Comments[0].Message.Body)
+	}
+	if data.Video.End != 100 || data.Streamer != testStreamer {
+		t.Errorf("got end %.2f and streamer %v", data.Video.End, data.Streamer)
+	}
+}
+
+func TestCompactChatJournalPartialLine(t *testing.T) {
+	journalPath, saveFile := writeTestJournal(t, models.Comments{Id: "a", ContentOffsetSeconds: 10}, models.Comments{Id: "b", ContentOffsetSeconds: 42})
+
+	// A crash can leave half a line at the end, which should not lose the rest
+	file, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("{\"_id\":\"broken\",\"content_off")
+	_ = file.Close()
+
+	// With no end given the video ends at the last comment
+	if _, err = CompactChatJournal(journalPath, saveFile, testStreamer, 0, models.Emotes{}); err != nil {
+		t.Fatal(err)
+	}
+	ids, data := commentIds(t, saveFile)
+	if ids != "ab" || data.Video.End != 42 {
+		t.Errorf("got comments %s ending at %.2f, want ab at 42", ids, data.Video.End)
+	}
+}
+
+func TestCompactChatJournalShifted(t *testing.T) {
+	journalPath, saveFile := writeTestJournal(t, models.Comments{Id: "a", ContentOffsetSeconds: 10}, models.Comments{Id: "b", ContentOffsetSeconds: 40})
+	shift := func(offset float64) float64 {
+		return offset - 5
+	}
+	if _, err := CompactChatJournalShifted(journalPath, saveFile, testStreamer, 100, models.Emotes{}, shift); err != nil {
+		t.Fatal(err)
+	}
+	_, data := commentIds(t, saveFile)
+	if data.Comments[0].ContentOffsetSeconds != 5 || data.Comments[1].ContentOffsetSeconds != 35 || data.Video.End != 95 {
+		t.Errorf("got offsets %.2f %.2f ending at %.2f, want 5 35 at 95",
+			data.Comments[0].ContentOffsetSeconds, data.Comments[1].ContentOffsetSeconds, data.Video.End)
+	}
+}
+
+func TestLoadChatHeader(t *testing.T) {
+	journalPath, saveFile := writeTestJournal(t, models.Comments{Id: "a", ContentOffsetSeconds: 1}, models.Comments{Id: "b", ContentOffsetSeconds: 2})
+	emotes := models.Emotes{Firstparty: []models.Firstparty{{ID: "25", Imagescale: 2, Data: "abcd"}}}
+	if _, err := CompactChatJournal(journalPath, saveFile, testStreamer, 0, emotes); err != nil {
+		t.Fatal(err)
+	}
+
+	streamer, loaded, err := LoadChatHeader(saveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamer != testStreamer {
+		t.Errorf("got streamer %v", streamer)
+	}
+	if len(loaded.Firstparty) != 1 || loaded.Firstparty[0].ID != "25" || loaded.Firstparty[0].Data != "abcd" {
+		t.Errorf("got emotes %v", loaded.Firstparty)
+	}
+	if _, _, err = LoadChatHeader(saveFile + ".missing"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,64 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/goldbattle/twitch_vods/models"
+	"github.com/nicklaw5/helix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func GetVodManifestPath(folder string, username string, vod helix.Video) string {
+
+	// Parse VOD date
+	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
+	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+
+	// The manifest lives inside of the vod segment folder
+	return filepath.Join(folder, strings.ToLower(username), yearFolder, vod.ID, "manifest.json")
+
+}
+
+func LoadVodManifest(folder string, username string, vod helix.Video) (models.VodManifest, error) {
+
+	// Load the file if it exists
+	manifest := models.VodManifest{}
+	file, err := ioutil.ReadFile(GetVodManifestPath(folder, username, vod))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(file, &manifest)
+	if err != nil {
+		return models.VodManifest{}, err
+	}
+	return manifest, nil
+
+}
+
+func SaveVodManifest(folder string, username string, vod helix.Video, manifest models.VodManifest) error {
+
+	// Create folders if needed to save into
+	saveFile := GetVodManifestPath(folder, username, vod)
+	err := os.MkdirAll(filepath.Dir(saveFile), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and then move it, so a crash never leaves a half written manifest
+	if manifest.CreatedAt.IsZero() {
+		manifest.CreatedAt = time.Now().UTC()
+	}
+	manifest.UpdatedAt = time.Now().UTC()
+	file, _ := json.MarshalIndent(manifest, "", " ")
+	err = ioutil.WriteFile(saveFile+".tmp", file, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(saveFile+".tmp", saveFile)
+
+}
@@ -0,0 +1,108 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/goldbattle/twitch_vods/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ModerationJournal is an append-only file of moderation events, one json object per line
+// Events are written as they happen, and the journal is only turned into the timeline once the stream ends
+type ModerationJournal struct {
+	mutex sync.Mutex
+	file  *os.File
+	Path  string
+}
+
+// OpenModerationJournal opens (or creates) a journal to append moderation events to
+func OpenModerationJournal(path string) (*ModerationJournal, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &ModerationJournal{file: file, Path: path}, nil
+}
+
+// Append writes the event to the end of the journal
+func (journal *ModerationJournal) Append(event models.ModerationEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	_, err = journal.file.Write(append(line, '\n'))
+	return err
+}
+
+func (journal *ModerationJournal) Close() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.file.Close()
+}
+
+// CompactModerationJournal writes the events of the journal into the moderation timeline, returning how many there were
+// If shift is given every offset is moved with it (e.g. to match a video with the ad breaks cut out)
+// Nothing is written if the journal has no events
+func CompactModerationJournal(journalPath string, saveFile string, streamer models.Streamer, shift func(offset float64) float64) (int, error) {
+	file, err := os.Open(journalPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	timeline := models.ModerationTimeline{Streamer: streamer}
+	reader := bufio.NewReader(file)
+	for {
+		line, errRead := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			event := models.ModerationEvent{}
+			if json.Unmarshal(line, &event) == nil {
+				if shift != nil {
+					event.ContentOffsetSeconds = shift(event.ContentOffsetSeconds)
+				}
+				timeline.Events = append(timeline.Events, event)
+			}
+		}
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			return 0, errRead
+		}
+	}
+	if len(timeline.Events) == 0 {
+		return 0, nil
+	}
+	return len(timeline.Events), SaveModerationTimeline(saveFile, timeline)
+}
+
+// SaveModerationTimeline writes the moderation events of a stream sorted by their offset
+func SaveModerationTimeline(saveFile string, timeline models.ModerationTimeline) error {
+
+	// Sort by when they happened in the stream
+	if timeline.Events == nil {
+		timeline.Events = make([]models.ModerationEvent, 0)
+	}
+	sort.SliceStable(timeline.Events, func(i, j int) bool {
+		return timeline.Events[i].ContentOffsetSeconds < timeline.Events[j].ContentOffsetSeconds
+	})
+
+	// Write to a temp file and then move it, so a crash never leaves a half written timeline
+	file, _ := json.MarshalIndent(timeline, "", " ")
+	err := ioutil.WriteFile(saveFile+".tmp", file, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(saveFile+".tmp", saveFile)
+
+}
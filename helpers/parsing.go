@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var vodIdRegex = regexp.MustCompile(`(?:videos/|^)(\d+)(?:[/?#].*)?$`)
+
+// ParseVodId returns the vod id from either a plain id or a twitch url (https://www.twitch.tv/videos/<id>)
+func ParseVodId(input string) (string, error) {
+	match := vodIdRegex.FindStringSubmatch(strings.TrimSpace(input))
+	if match == nil {
+		return "", errors.New("unable to find vod id in " + input)
+	}
+	return match[1], nil
+}
+
+// ParseTimestamp converts a timestamp into seconds
+// Supports plain seconds (3723), clock format (1:02:03 or 62:03) and go durations (1h2m3s)
+func ParseTimestamp(input string) (float64, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return 0, errors.New("empty timestamp")
+	}
+	if strings.ContainsAny(input, "hms") {
+		duration, err := time.ParseDuration(input)
+		if err != nil {
+			return 0, err
+		}
+		return duration.Seconds(), nil
+	}
+	parts := strings.Split(input, ":")
+	if len(parts) > 3 {
+		return 0, errors.New("invalid timestamp " + input)
+	}
+	seconds := 0.0
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			return 0, errors.New("invalid timestamp " + input)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// FormatTimestamp converts seconds into a hh:mm:ss clock string, negative values are prefixed with a minus
+func FormatTimestamp(seconds float64) string {
+	sign := ""
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	total := int(seconds)
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, total/3600, total/60%60, total%60)
+}
@@ -0,0 +1,79 @@
+package helpers
+
+import (
+	"testing"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{"3723", 3723, false},
+		{"12.5", 12.5, false},
+		{"1:02:03", 3723, false},
+		{"62:03", 3723, false},
+		{" 0:30 ", 30, false},
+		{"1h2m3s", 3723, false},
+		{"90s", 90, false},
+		{"1h", 3600, false},
+		{"", 0, true},
+		{"1:2:3:4", 0, true},
+		{"1:-2", 0, true},
+		{"abc", 0, true},
+		{"1hm", 0, true},
+	}
+	for _, test := range tests {
+		seconds, err := ParseTimestamp(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: unexpected error %v", test.input, err)
+			continue
+		}
+		if seconds != test.want {
+			t.Errorf("%q: got %.2f, want %.2f", test.input, seconds, test.want)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "00:00:00"},
+		{59.9, "00:00:59"},
+		{3723, "01:02:03"},
+		{90000, "25:00:00"},
+	}
+	for _, test := range tests {
+		if got := FormatTimestamp(test.seconds); got != test.want {
+			t.Errorf("%.2f: got %s, want %s", test.seconds, got, test.want)
+		}
+	}
+}
+
+func TestParseVodId(t *testing.T) {
+	for _, input := range []string{
+		"1234567890",
+		"https://www.twitch.tv/videos/1234567890",
+		"https://www.twitch.tv/videos/1234567890?t=1h2m3s",
+	} {
+		if id, err := ParseVodId(input); err != nil || id != "1234567890" {
+			t.Errorf("%q: got %s %v", input, id, err)
+		}
+	}
+	if id, err := ParseVodId("https://www.twitch.tv/someone"); err == nil {
+		t.Errorf("channel url should not be a vod, got %s", id)
+	}
+}
+
+func TestFormatTimestampNegative(t *testing.T) {
+	// Events from before the recording started have a negative offset
+	if got := FormatTimestamp(-5); got != "-00:00:05" {
+		t.Errorf("got %s, want -00:00:05", got)
+	}
+	if got := FormatTimestamp(-3723.5); got != "-01:02:03" {
+		t.Errorf("got %s, want -01:02:03", got)
+	}
+}
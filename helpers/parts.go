@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"encoding/json"
+	"github.com/goldbattle/twitch_vods/models"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// livePartsMutex is held while updating a parts index, since the next part starts while the last one is finishing
+var livePartsMutex sync.Mutex
+
+// UpdateLivePart adds the part to the parts index of a broadcast, replacing the one with the same prefix if it is there
+func UpdateLivePart(saveFile string, id string, idStream string, userName string, part models.LivePart) error {
+	livePartsMutex.Lock()
+	defer livePartsMutex.Unlock()
+
+	// Load the existing index if we have one
+	index := models.LivePartsIndex{}
+	if file, err := ioutil.ReadFile(saveFile); err == nil {
+		err = json.Unmarshal(file, &index)
+		if err != nil {
+			return err
+		}
+	}
+	index.Id = id
+	index.IdStream = idStream
+	index.UserName = userName
+
+	// Replace or append our part, and keep them in file order
+	found := false
+	for i := range index.Parts {
+		if index.Parts[i].Prefix == part.Prefix {
+			index.Parts[i] = part
+			found = true
+		}
+	}
+	if !found {
+		index.Parts = append(index.Parts, part)
+	}
+	sort.SliceStable(index.Parts, func(i, j int) bool {
+		return index.Parts[i].Prefix < index.Parts[j].Prefix
+	})
+
+	// Write to a temp file and then move it, so a crash never leaves a half written index
+	file, _ := json.MarshalIndent(index, "", " ")
+	err := ioutil.WriteFile(saveFile+".tmp", file, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(saveFile+".tmp", saveFile)
+}
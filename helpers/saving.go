@@ -31,7 +31,32 @@ func IsChatDownloaded(folder string, username string, usernameId string, vod hel
 
 }
 
-func SaveChatToFile(folder string, username string, usernameId string, vod helix.Video, comments []models.Comments) {
+func LoadChatFromFile(folder string, username string, usernameId string, vod helix.Video) (models.ChatRenderStructure, error) {
+
+	// Parse VOD date
+	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
+	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
+
+	// Load our file if it exists
+	saveFile := filepath.Join(folder, strings.ToLower(username), yearFolder, vod.ID+"_chat.json")
+	return LoadChatFile(saveFile)
+
+}
+
+func LoadChatFile(saveFile string) (models.ChatRenderStructure, error) {
+	data := models.ChatRenderStructure{}
+	file, err := ioutil.ReadFile(saveFile)
+	if err != nil {
+		return data, err
+	}
+	err = json.Unmarshal(file, &data)
+	if err != nil {
+		return models.ChatRenderStructure{}, err
+	}
+	return data, nil
+}
+
+func SaveChatToFile(folder string, username string, usernameId string, vod helix.Video, comments []models.Comments, emotes models.Emotes) {
 
 	// Parse VOD date
 	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
@@ -60,8 +85,16 @@ func SaveChatToFile(folder string, username string, usernameId string, vod helix
 	data.Video.Start = 0.0
 	data.Video.End = latestCommentOffset
 	data.Comments = comments
-	data.Emotes.Firstparty = make([]models.Firstparty, 0)
-	data.Emotes.Thirdparty = make([]models.Thirdparty, 0)
+	data.Emotes = emotes
+	if data.Emotes.Firstparty == nil {
+		data.Emotes.Firstparty = make([]models.Firstparty, 0)
+	}
+	if data.Emotes.Thirdparty == nil {
+		data.Emotes.Thirdparty = make([]models.Thirdparty, 0)
+	}
+	if data.Emotes.TwitchBadges == nil {
+		data.Emotes.TwitchBadges = make([]models.TwitchBadge, 0)
+	}
 	file, _ := json.MarshalIndent(data, "", " ")
 	_ = ioutil.WriteFile(saveFile, file, 0644)
 
@@ -69,15 +102,26 @@ func SaveChatToFile(folder string, username string, usernameId string, vod helix
 
 func IsVodDownloaded(folder string, username string, usernameId string, vod helix.Video) bool {
 
-	// Parse VOD date
-	tm, _ := time.Parse("2006-01-02T15:04:05Z", vod.CreatedAt)
-	yearFolder := strconv.Itoa(tm.Year()) + "-" + fmt.Sprintf("%02d", int(tm.Month()))
-
-	// Check if our file exists
-	saveDir := filepath.Join(folder, strings.ToLower(username), yearFolder, vod.ID)
-	if _, err := os.Stat(saveDir); err == nil {
-		return true
+	// Only a vod with a complete manifest is downloaded
+	// NOTE: a folder on its own might be from a download that crashed halfway through
+	manifest, err := LoadVodManifest(folder, username, vod)
+	if err != nil {
+		return false
 	}
-	return false
+	return manifest.Status == models.ManifestStatusComplete
 
 }
+
+// NextFilePrefix returns the first "<id>_000", "<id>_001", ... which no file in the folder starts with
+// This is used so live recordings and chat logs of the same stream never overwrite each other
+func NextFilePrefix(saveDir string, id string) string {
+	fileCounter := 0
+	for {
+		filePrefix := id + "_" + fmt.Sprintf("%03d", fileCounter)
+		matches, _ := filepath.Glob(filepath.Join(saveDir, filePrefix+"*"))
+		if len(matches) < 1 {
+			return filePrefix
+		}
+		fileCounter++
+	}
+}
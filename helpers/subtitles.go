@@ -0,0 +1,175 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/goldbattle/twitch_vods/models"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// subtitleCue is a single block of stacked chat messages shown between start and end (seconds)
+type subtitleCue struct {
+	Start    float64
+	End      float64
+	Comments []models.Comments
+}
+
+// buildSubtitleCues figures out which messages are on screen at each point in time
+// Each message is shown for duration seconds after its offset, and at most maxLines (newest) are stacked
+func buildSubtitleCues(comments []models.Comments, duration float64, maxLines int) []subtitleCue {
+
+	// Sort by when they appear
+	sorted := make([]models.Comments, len(comments))
+	copy(sorted, comments)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ContentOffsetSeconds < sorted[j].ContentOffsetSeconds
+	})
+
+	// Every time a message appears or disappears the screen changes
+	var times []float64
+	for _, comment := range sorted {
+		times = append(times, comment.ContentOffsetSeconds, comment.ContentOffsetSeconds+duration)
+	}
+	sort.Float64s(times)
+
+	// Create a cue for each change, only keeping the newest messages
+	// NOTE: messages appear in order, so the visible window only ever moves forward
+	var cues []subtitleCue
+	first := 0
+	next := 0
+	for i := 0; i+1 < len(times); i++ {
+		start, end := times[i], times[i+1]
+		if end <= start {
+			continue
+		}
+		for next < len(sorted) && sorted[next].ContentOffsetSeconds <= start {
+			next++
+		}
+		for first < next && sorted[first].ContentOffsetSeconds+duration <= start {
+			first++
+		}
+		visible := sorted[first:next]
+		if len(visible) < 1 {
+			continue
+		}
+		if maxLines > 0 && len(visible) > maxLines {
+			visible = visible[len(visible)-maxLines:]
+		}
+
+		// Extend the last cue if nothing changed
+		if len(cues) > 0 && cues[len(cues)-1].End == start && sameComments(cues[len(cues)-1].Comments, visible) {
+			cues[len(cues)-1].End = end
+			continue
+		}
+		cues = append(cues, subtitleCue{Start: start, End: end, Comments: visible})
+	}
+	return cues
+
+}
+
+func sameComments(a []models.Comments, b []models.Comments) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Id != b[i].Id {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSubtitleTime converts seconds into a hh:mm:ss<sep>mmm timestamp (srt uses a comma, vtt a period)
+func formatSubtitleTime(seconds float64, separator string) string {
+	ms := int(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// formatAssTime converts seconds into a h:mm:ss.cc timestamp
+func formatAssTime(seconds float64) string {
+	cs := int(seconds*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// assColor converts a #RRGGBB html color into the &HBBGGRR& format of ASS
+func assColor(color *string) string {
+	if color == nil || len(*color) != 7 || !strings.HasPrefix(*color, "#") {
+		return ""
+	}
+	if _, err := strconv.ParseUint((*color)[1:], 16, 32); err != nil {
+		return ""
+	}
+	hex := strings.ToUpper((*color)[1:])
+	return "&H" + hex[4:6] + hex[2:4] + hex[0:2] + "&"
+}
+
+func commentDisplayName(comment models.Comments) string {
+	if comment.Commenter.DisplayName != "" {
+		return comment.Commenter.DisplayName
+	}
+	return comment.Commenter.Name
+}
+
+// ExportChatToSubtitles writes the chat as a srt, vtt or ass subtitle track
+// Each message is shown at its offset for duration seconds, with at most maxLines messages stacked on screen
+func ExportChatToSubtitles(data models.ChatRenderStructure, saveFile string, format string, duration float64, maxLines int) error {
+
+	// Get what is on screen and when
+	if duration <= 0 {
+		return errors.New("subtitle duration must be positive")
+	}
+	cues := buildSubtitleCues(data.Comments, duration, maxLines)
+
+	// Write each cue in the requested format
+	var buffer bytes.Buffer
+	switch strings.ToLower(format) {
+	case "srt":
+		for idx, cue := range cues {
+			buffer.WriteString(strconv.Itoa(idx+1) + "\n")
+			buffer.WriteString(formatSubtitleTime(cue.Start, ",") + " --> " + formatSubtitleTime(cue.End, ",") + "\n")
+			for _, comment := range cue.Comments {
+				buffer.WriteString(commentDisplayName(comment) + ": " + strings.ReplaceAll(comment.Message.Body, "\n", " ") + "\n")
+			}
+			buffer.WriteString("\n")
+		}
+	case "vtt":
+		escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\n", " ")
+		buffer.WriteString("WEBVTT\n\n")
+		for _, cue := range cues {
+			buffer.WriteString(formatSubtitleTime(cue.Start, ".") + " --> " + formatSubtitleTime(cue.End, ".") + "\n")
+			for _, comment := range cue.Comments {
+				buffer.WriteString("<v " + escaper.Replace(commentDisplayName(comment)) + ">" + escaper.Replace(commentDisplayName(comment)) + ": " + escaper.Replace(comment.Message.Body) + "\n")
+			}
+			buffer.WriteString("\n")
+		}
+	case "ass":
+		escaper := strings.NewReplacer("{", "(", "}", ")", "\\", "\u29f5", "\n", " ")
+		buffer.WriteString("[Script Info]\n")
+		buffer.WriteString("Title: " + data.Streamer.Name + " chat\n")
+		buffer.WriteString("ScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\nWrapStyle: 0\n\n")
+		buffer.WriteString("[V4+ Styles]\n")
+		buffer.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+		buffer.WriteString("Style: Default,Arial,32,&H00FFFFFF,&H00FFFFFF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,2,0,7,20,20,20,1\n\n")
+		buffer.WriteString("[Events]\n")
+		buffer.WriteString("Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+		for _, cue := range cues {
+			var lines []string
+			for _, comment := range cue.Comments {
+				name := escaper.Replace(commentDisplayName(comment))
+				if color := assColor(comment.Message.UserColor); color != "" {
+					name = "{\\c" + color + "}" + name + "{\\c}"
+				}
+				lines = append(lines, name+": "+escaper.Replace(comment.Message.Body))
+			}
+			buffer.WriteString("Dialogue: 0," + formatAssTime(cue.Start) + "," + formatAssTime(cue.End) + ",Default,,0,0,0,," + strings.Join(lines, "\\N") + "\n")
+		}
+	default:
+		return errors.New("unknown subtitle format " + format)
+	}
+	return ioutil.WriteFile(saveFile, buffer.Bytes(), 0644)
+
+}
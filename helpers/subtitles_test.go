@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"fmt"
+	"github.com/goldbattle/twitch_vods/models"
+	"strings"
+	"testing"
+)
+
+// describeCues writes each cue as "start-end:ids" so they are easy to compare
+func describeCues(comments []models.Comments, duration float64, maxLines int) string {
+	var out []string
+	for _, cue := range buildSubtitleCues(comments, duration, maxLines) {
+		ids := ""
+		for _, comment := range cue.Comments {
+			ids += comment.Id
+		}
+		out = append(out, fmt.Sprintf("%g-%g:%s", cue.Start, cue.End, ids))
+	}
+	return strings.Join(out, " ")
+}
+
+func TestBuildSubtitleCues(t *testing.T) {
+	// Each comment id is a single letter, sent at the offset of the same index
+	comments := func(ids string, offsets ...float64) []models.Comments {
+		var out []models.Comments
+		for i, offset := range offsets {
+			out = append(out, models.Comments{Id: ids[i : i+1], ContentOffsetSeconds: offset})
+		}
+		return out
+	}
+	if got := describeCues(nil, 5, 3); got != "" {
+		t.Errorf("got cues %q without comments", got)
+	}
+	if got := describeCues(comments("a", 10), 5, 3); got != "10-15:a" {
+		t.Errorf("single comment: got %q", got)
+	}
+
+	// Overlapping comments stack, in order of their offset
+	if got := describeCues(comments("ab", 0, 2), 5, 3); got != "0-2:a 2-5:ab 5-7:b" {
+		t.Errorf("overlapping comments: got %q", got)
+	}
+	if got := describeCues(comments("ba", 2, 0), 5, 3); got != "0-2:a 2-5:ab 5-7:b" {
+		t.Errorf("unsorted comments: got %q", got)
+	}
+	if got := describeCues(comments("abc", 0, 1, 2), 10, 2); got != "0-1:a 1-2:ab 2-11:bc 11-12:c" {
+		t.Errorf("only the newest lines should be kept: got %q", got)
+	}
+	if got := describeCues(comments("ab", 0, 20), 5, 3); got != "0-5:a 20-25:b" {
+		t.Errorf("gap between comments: got %q", got)
+	}
+	if got := describeCues(comments("ab", 3, 3), 5, 0); got != "3-8:ab" {
+		t.Errorf("same offset: got %q", got)
+	}
+}
@@ -3,18 +3,20 @@ package models
 import "time"
 
 type Comments struct {
-	Id                   string    `json:"_id"`
-	CreatedAt            time.Time `json:"created_at"`
-	UpdatedAt            time.Time `json:"updated_at"`
-	ChannelId            string    `json:"channel_id"`
-	ContentType          string    `json:"content_type"`
-	ContentId            string    `json:"content_id"`
-	ContentOffsetSeconds float64   `json:"content_offset_seconds"`
-	Commenter            Commenter `json:"commenter"`
-	Source               string    `json:"source"`
-	State                string    `json:"state"`
-	Message              Message   `json:"message"`
-	MoreReplies          bool      `json:"more_replies"`
+	Id                   string             `json:"_id"`
+	CreatedAt            time.Time          `json:"created_at"`
+	UpdatedAt            time.Time          `json:"updated_at"`
+	ChannelId            string             `json:"channel_id"`
+	ContentType          string             `json:"content_type"`
+	ContentId            string             `json:"content_id"`
+	ContentOffsetSeconds float64            `json:"content_offset_seconds"`
+	Commenter            Commenter          `json:"commenter"`
+	Source               string             `json:"source"`
+	State                string             `json:"state"`
+	Message              Message            `json:"message"`
+	MoreReplies          bool               `json:"more_replies"`
+	LiveOnly             bool               `json:"live_only,omitempty"`
+	Moderation           *CommentModeration `json:"moderation,omitempty"`
 }
 
 type Commenter struct {
@@ -29,14 +31,14 @@ type Commenter struct {
 }
 
 type Message struct {
-	Body             string            `json:"body"`
-	BitsSpent        int               `json:"bits_spent"`
-	Fragments        []Fragment        `json:"fragments"`
-	IsAction         bool              `json:"is_action"`
-	UserBadges       []UserBadge       `json:"user_badges"`
-	UserColor        *string           `json:"user_color"`
+	Body             string           `json:"body"`
+	BitsSpent        int              `json:"bits_spent"`
+	Fragments        []Fragment       `json:"fragments"`
+	IsAction         bool             `json:"is_action"`
+	UserBadges       []UserBadge      `json:"user_badges"`
+	UserColor        *string          `json:"user_color"`
 	UserNoticeParams UserNoticeParams `json:"user_notice_params"`
-	Emoticons        []Emoticon        `json:"emoticons"`
+	Emoticons        []Emoticon       `json:"emoticons"`
 }
 
 type Fragment struct {
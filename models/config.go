@@ -1,19 +1,38 @@
 package models
 
 type ConfigurationFile struct {
-	TwitchClientId    string   `json:"twitch_client_id"`
-	TwitchSecretId    string   `json:"twitch_secret_id"`
-	SaveDirectory     string   `json:"save_directory"`
-	Streamlink        string   `json:"streamlink"`
-	Ffmpeg            string   `json:"ffmpeg"`
-	VideoResolution   string   `json:"video_resolution"`
-	DownloadNum       int      `json:"download_num"`
-	SkipIfOlderMin    int      `json:"skip_if_older_min"`
-	ChannelsChat      []string `json:"channels_chat"`
-	ChannelsVideo     []string `json:"channels_video"`
-	ChannelsLive      []string `json:"channels_live"`
-	ChannelsLiveChat  []string `json:"channels_live_chat"`
-	StreamLinkOptions []string `json:"streamlink_options"`
-	QueryVodsMin      int      `json:"query_vods_min"`
-	QueryLiveMin      int      `json:"query_live_min"`
+	TwitchClientId    string         `json:"twitch_client_id"`
+	TwitchSecretId    string         `json:"twitch_secret_id"`
+	SaveDirectory     string         `json:"save_directory"`
+	Streamlink        string         `json:"streamlink"`
+	Ffmpeg            string         `json:"ffmpeg"`
+	VideoResolution   string         `json:"video_resolution"`
+	VideoQualities    []string       `json:"video_qualities"`
+	DownloadNum       int            `json:"download_num"`
+	SkipIfOlderMin    int            `json:"skip_if_older_min"`
+	ChannelsChat      []string       `json:"channels_chat"`
+	ChannelsVideo     []string       `json:"channels_video"`
+	ChannelsLive      []string       `json:"channels_live"`
+	ChannelsLiveChat  []string       `json:"channels_live_chat"`
+	ChannelsChatLog   []string       `json:"channels_chat_log"`
+	ChannelsNative    []string       `json:"channels_native"`
+	StreamLinkOptions []string       `json:"streamlink_options"`
+	QueryVodsMin      int            `json:"query_vods_min"`
+	QueryLiveMin      int            `json:"query_live_min"`
+	DownloadWorkers   int            `json:"download_workers"`
+	DownloadRetries   int            `json:"download_retries"`
+	VodContainer      string         `json:"vod_container"`
+	VodDeleteSegments bool           `json:"vod_delete_segments"`
+	Backfill          bool           `json:"backfill"`
+	BackfillType      string         `json:"backfill_type"`
+	BackfillAfter     string         `json:"backfill_after"`
+	BackfillBefore    string         `json:"backfill_before"`
+	EmbedEmotes       bool           `json:"embed_emotes"`
+	EmoteProviders    EmoteProviders `json:"emote_providers"`
+	EmbedBadges       bool           `json:"embed_badges"`
+	MergeLiveChat     bool           `json:"merge_live_chat"`
+	LiveCutAds        bool           `json:"live_cut_ads"`
+	LiveStallSec      int            `json:"live_stall_sec"`
+	LiveSplitMin      int            `json:"live_split_min"`
+	LiveSplitMb       int            `json:"live_split_mb"`
 }
@@ -0,0 +1,45 @@
+package models
+
+type EmoteProviders struct {
+	TwitchCdn  string `json:"twitch_cdn"`
+	BttvApi    string `json:"bttv_api"`
+	BttvCdn    string `json:"bttv_cdn"`
+	SevenTvApi string `json:"seventv_api"`
+	SevenTvCdn string `json:"seventv_cdn"`
+}
+
+type ThirdPartyEmote struct {
+	ID         string
+	Name       string
+	ImageUrl   string
+	ImageScale int
+}
+
+type BttvEmote struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+}
+
+type BttvChannelResponse struct {
+	ChannelEmotes []BttvEmote `json:"channelEmotes"`
+	SharedEmotes  []BttvEmote `json:"sharedEmotes"`
+}
+
+type FfzEmote struct {
+	ID     int               `json:"id"`
+	Code   string            `json:"code"`
+	Images map[string]string `json:"images"`
+}
+
+type SevenTvEmote struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type SevenTvEmoteSet struct {
+	Emotes []SevenTvEmote `json:"emotes"`
+}
+
+type SevenTvUserResponse struct {
+	EmoteSet SevenTvEmoteSet `json:"emote_set"`
+}
@@ -23,8 +23,9 @@ type Video struct {
 }
 
 type Emotes struct {
-	Thirdparty []Thirdparty `json:"thirdParty"`
-	Firstparty []Firstparty `json:"firstParty"`
+	Thirdparty   []Thirdparty  `json:"thirdParty"`
+	Firstparty   []Firstparty  `json:"firstParty"`
+	TwitchBadges []TwitchBadge `json:"twitchBadges"`
 }
 
 type Thirdparty struct {
@@ -40,6 +41,11 @@ type Firstparty struct {
 	Data       string `json:"data"`
 }
 
+type TwitchBadge struct {
+	Name     string            `json:"name"`
+	Versions map[string]string `json:"versions"`
+}
+
 type MappingStreamToVod struct {
 	Data map[string]helix.Video `json:"data"`
 }
@@ -58,6 +64,59 @@ type StreamMetaData struct {
 	Moments  []Moment `json:"moments"`
 	MutedSegments []interface{} `json:"muted_segments"`
 	RecordedAt    time.Time     `json:"recorded_at"`
+	ChatAlignment *ChatAlignment `json:"chat_alignment,omitempty"`
+	ChatOutages   []ChatOutage   `json:"chat_outages,omitempty"`
+	AdBreaks      []AdBreak      `json:"ad_breaks,omitempty"`
+	RecorderExit  *RecorderExit  `json:"recorder_exit,omitempty"`
+	RestartOf     string         `json:"restart_of,omitempty"`
+	Variant       *ManifestVariant `json:"variant,omitempty"`
+}
+
+// RecorderExit is why the video recording stopped
+// Reason is "ended" when the stream ended, "stopped" if we were asked to stop, "stalled" if no new video came,
+// "split" if the part got too long or big, else "error"
+// If it split or stalled while the channel was still live, a new part is recorded straight away (with restart_of set to this one)
+type RecorderExit struct {
+	Recorder  string    `json:"recorder"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message,omitempty"`
+	ExitCode  int       `json:"exit_code"`
+	Time      time.Time `json:"time"`
+	Restarted bool      `json:"restarted"`
+}
+
+// AdBreak is an ad which twitch stitched into the live stream
+// The offset is from the start of the recording, before any ad breaks were cut out of it
+// Source is how it was found, either from a stitched ad "daterange", the segment "title" or what "streamlink" logged
+type AdBreak struct {
+	Id              string    `json:"id,omitempty"`
+	Source          string    `json:"source"`
+	Start           time.Time `json:"start"`
+	OffsetSeconds   float64   `json:"offset_seconds"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Cut             bool      `json:"cut"`
+}
+
+// ChatOutage is a time our irc connection was down, so chat messages between start and end are missing
+type ChatOutage struct {
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	DurationSeconds    float64   `json:"duration_seconds"`
+	StartOffsetSeconds float64   `json:"start_offset_seconds"`
+	EndOffsetSeconds   float64   `json:"end_offset_seconds"`
+}
+
+// ChatAlignment is the time that chat offset zero is at, and how it was found
+// Reference is either "program_date_time" (first recorded hls segment), "estimated_program_date_time" (the segment
+// streamlink most likely started at), "stream_started_at" or "file_created"
+// Skew is how many seconds after the reference our video file was created, and stream offset is how far into the broadcast the reference is
+type ChatAlignment struct {
+	Reference           string    `json:"reference"`
+	ReferenceTime       time.Time `json:"reference_time"`
+	FileCreatedAt       time.Time `json:"file_created_at"`
+	StreamStartedAt     time.Time `json:"stream_started_at"`
+	SkewSeconds         float64   `json:"skew_seconds"`
+	StreamOffsetSeconds float64   `json:"stream_offset_seconds"`
 }
 
 type Moment struct {
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	ManifestStatusPending  = "pending"
+	ManifestStatusPartial  = "partial"
+	ManifestStatusComplete = "complete"
+	ManifestStatusFailed   = "failed"
+)
+
+type VodManifest struct {
+	VodId           string            `json:"vod_id"`
+	Status          string            `json:"status"`
+	Variant         ManifestVariant   `json:"variant"`
+	Playlist        string            `json:"playlist"`
+	Segments        []ManifestSegment `json:"segments"`
+	Output          string            `json:"output"`
+	SegmentsDeleted bool              `json:"segments_deleted"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+type ManifestVariant struct {
+	Name         string   `json:"name"`
+	Resolution   string   `json:"resolution"`
+	Bandwidth    uint32   `json:"bandwidth"`
+	FrameRate    float64  `json:"frame_rate"`
+	Uri          string   `json:"uri"`
+	SwitchedFrom []string `json:"switched_from,omitempty"`
+}
+
+type ManifestSegment struct {
+	Uri      string  `json:"uri"`
+	Duration float64 `json:"duration"`
+	Size     int64   `json:"size"`
+	Sha256   string  `json:"sha256"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// ModerationType is what happened in chat
+type ModerationType string
+
+const (
+	ModerationClear     ModerationType = "clear"
+	ModerationTimeout   ModerationType = "timeout"
+	ModerationBan       ModerationType = "ban"
+	ModerationDelete    ModerationType = "delete"
+	ModerationRoomState ModerationType = "roomstate"
+	ModerationNotice    ModerationType = "notice"
+	ModerationRaid      ModerationType = "raid"
+)
+
+// ModerationEvent is a single CLEARCHAT, CLEARMSG, ROOMSTATE, NOTICE or raid event of a live chat
+type ModerationEvent struct {
+	Type                 ModerationType `json:"type"`
+	ContentOffsetSeconds float64        `json:"content_offset_seconds"`
+	CreatedAt            time.Time      `json:"created_at"`
+	UserId               string         `json:"user_id,omitempty"`
+	UserName             string         `json:"user_name,omitempty"`
+	MessageId            string         `json:"message_id,omitempty"`
+	Message              string         `json:"message,omitempty"`
+	Duration             int            `json:"duration,omitempty"`
+	Viewers              int            `json:"viewers,omitempty"`
+	NoticeId             string         `json:"notice_id,omitempty"`
+	State                map[string]int `json:"state,omitempty"`
+}
+
+// ModerationTimeline is all moderation events of a stream sorted by stream offset
+type ModerationTimeline struct {
+	Streamer Streamer          `json:"streamer"`
+	Events   []ModerationEvent `json:"events"`
+}
+
+// CommentModeration is set on a comment which was removed by a moderator
+type CommentModeration struct {
+	Type                 ModerationType `json:"type"`
+	ContentOffsetSeconds float64        `json:"content_offset_seconds"`
+	Duration             int            `json:"duration,omitempty"`
+}
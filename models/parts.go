@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// LivePartsIndex lists the parts (file prefixes) which a single live broadcast was recorded into
+type LivePartsIndex struct {
+	Id       string     `json:"id"`
+	IdStream string     `json:"id_stream"`
+	UserName string     `json:"user_name"`
+	Parts    []LivePart `json:"parts"`
+}
+
+// LivePart is one recording of the broadcast, with its own video, chat and info files
+// RestartOf is the part this one continues from, if the last one was split or stalled
+// ExitReason is the reason of its recorder exit, or "remux_error" if ffmpeg failed and the .tmp.mp4 was kept
+type LivePart struct {
+	Prefix          string    `json:"prefix"`
+	RestartOf       string    `json:"restart_of,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	EndedAt         time.Time `json:"ended_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	SizeBytes       int64     `json:"size_bytes"`
+	ExitReason      string    `json:"exit_reason,omitempty"`
+}
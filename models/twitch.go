@@ -1,8 +1,53 @@
 package models
 
-type CommentsV5ApiResponse struct {
-	Comments []Comments `json:"comments"`
-	Next     string     `json:"_next"`
+import "time"
+
+type GraphQLVideoCommentsResponse struct {
+	Data struct {
+		Video *struct {
+			ID       string `json:"id"`
+			Comments *struct {
+				Edges []struct {
+					Cursor string              `json:"cursor"`
+					Node   GraphQLVideoComment `json:"node"`
+				} `json:"edges"`
+				PageInfo struct {
+					HasNextPage     bool `json:"hasNextPage"`
+					HasPreviousPage bool `json:"hasPreviousPage"`
+				} `json:"pageInfo"`
+			} `json:"comments"`
+		} `json:"video"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type GraphQLVideoComment struct {
+	ID        string `json:"id"`
+	Commenter *struct {
+		ID          string `json:"id"`
+		Login       string `json:"login"`
+		DisplayName string `json:"displayName"`
+	} `json:"commenter"`
+	ContentOffsetSeconds float64   `json:"contentOffsetSeconds"`
+	CreatedAt            time.Time `json:"createdAt"`
+	Message              struct {
+		Fragments []struct {
+			Emote *struct {
+				ID      string `json:"id"`
+				EmoteID string `json:"emoteID"`
+				From    int    `json:"from"`
+			} `json:"emote"`
+			Text string `json:"text"`
+		} `json:"fragments"`
+		UserBadges []struct {
+			ID      string `json:"id"`
+			SetID   string `json:"setID"`
+			Version string `json:"version"`
+		} `json:"userBadges"`
+		UserColor *string `json:"userColor"`
+	} `json:"message"`
 }
 
 type GraphQLVideoPlaybackAccessResponse struct {
@@ -0,0 +1,44 @@
+package twitch
+
+import (
+	"errors"
+	"github.com/nicklaw5/helix"
+)
+
+// GetChatBadges returns the global badge sets along with the badge sets of the channel
+// Channel badges (e.g. subscriber, bits) replace the global versions with the same set id and version
+func GetChatBadges(client *helix.Client, channelId string) (map[string]map[string]helix.BadgeVersion, error) {
+
+	// Get global badges
+	badges := make(map[string]map[string]helix.BadgeVersion)
+	respGlobal, err := client.GetGlobalChatBadges()
+	if err != nil {
+		return nil, err
+	}
+	if respGlobal.ErrorMessage != "" {
+		return nil, errors.New(respGlobal.ErrorMessage)
+	}
+
+	// Get channel badges
+	respChannel, err := client.GetChannelChatBadges(&helix.GetChatBadgeParams{
+		BroadcasterID: channelId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if respChannel.ErrorMessage != "" {
+		return nil, errors.New(respChannel.ErrorMessage)
+	}
+
+	// Merge them together
+	for _, set := range append(respGlobal.Data.Badges, respChannel.Data.Badges...) {
+		if _, ok := badges[set.SetID]; !ok {
+			badges[set.SetID] = make(map[string]helix.BadgeVersion)
+		}
+		for _, version := range set.Versions {
+			badges[set.SetID][version.ID] = version
+		}
+	}
+	return badges, nil
+
+}
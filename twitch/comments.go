@@ -0,0 +1,51 @@
+package twitch
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/goldbattle/twitch_vods/models"
+)
+
+// GetVideoComments requests a single page of vod chat through the VideoCommentsByOffsetOrCursor GQL operation
+// The first page is requested by content offset (seconds), following pages should pass the cursor of the last comment
+func GetVideoComments(vodId string, offset float64, cursor string) (models.GraphQLVideoCommentsResponse, error) {
+
+	// Create the persisted query, which takes either a cursor or an offset
+	variables := map[string]interface{}{
+		"videoID": vodId,
+	}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	} else {
+		variables["contentOffsetSeconds"] = int(offset)
+	}
+	jsonPayload := map[string]interface{}{
+		"operationName": "VideoCommentsByOffsetOrCursor",
+		"variables":     variables,
+		"extensions": map[string]interface{}{
+			"persistedQuery": map[string]interface{}{
+				"version":    1,
+				"sha256Hash": "b70a3591ff0f4e0313d126c6a1502d79a1c02baebb288227c582044aa76adf6a",
+			},
+		},
+	}
+	body, err := CallGraphQl("https://gql.twitch.tv/gql", jsonPayload)
+	if err != nil {
+		return models.GraphQLVideoCommentsResponse{}, err
+	}
+
+	// Convert to the api response
+	apiResponse := models.GraphQLVideoCommentsResponse{}
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return models.GraphQLVideoCommentsResponse{}, errors.New("error decoding GQL api endpoint")
+	}
+	if len(apiResponse.Errors) > 0 {
+		return models.GraphQLVideoCommentsResponse{}, errors.New("GQL error: " + apiResponse.Errors[0].Message)
+	}
+	if apiResponse.Data.Video == nil || apiResponse.Data.Video.Comments == nil {
+		return models.GraphQLVideoCommentsResponse{}, errors.New("GQL returned no video comments")
+	}
+	return apiResponse, nil
+
+}
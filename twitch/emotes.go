@@ -0,0 +1,158 @@
+package twitch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/goldbattle/twitch_vods/models"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultEmoteProviders returns the public endpoints, any value set in the config will override these
+func DefaultEmoteProviders(config models.EmoteProviders) models.EmoteProviders {
+	if config.TwitchCdn == "" {
+		config.TwitchCdn = "https://static-cdn.jtvnw.net"
+	}
+	if config.BttvApi == "" {
+		config.BttvApi = "https://api.betterttv.net"
+	}
+	if config.BttvCdn == "" {
+		config.BttvCdn = "https://cdn.betterttv.net"
+	}
+	if config.SevenTvApi == "" {
+		config.SevenTvApi = "https://7tv.io"
+	}
+	if config.SevenTvCdn == "" {
+		config.SevenTvCdn = "https://cdn.7tv.app"
+	}
+	config.TwitchCdn = strings.TrimSuffix(config.TwitchCdn, "/")
+	config.BttvApi = strings.TrimSuffix(config.BttvApi, "/")
+	config.BttvCdn = strings.TrimSuffix(config.BttvCdn, "/")
+	config.SevenTvApi = strings.TrimSuffix(config.SevenTvApi, "/")
+	config.SevenTvCdn = strings.TrimSuffix(config.SevenTvCdn, "/")
+	return config
+}
+
+// TwitchEmoteUrl returns the 2x image of a first party emote
+func TwitchEmoteUrl(providers models.EmoteProviders, emoteId string) string {
+	return providers.TwitchCdn + "/emoticons/v2/" + emoteId + "/default/dark/2.0"
+}
+
+// DownloadImage returns the raw bytes of an image
+func DownloadImage(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("invalid response code: " + strconv.Itoa(res.StatusCode))
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// getJson requests the url and decodes it into the passed object
+// A 404 is returned as a nil error with found = false, since providers use it when a channel has no emotes
+func getJson(url string, out interface{}) (bool, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("invalid response code %d for %s", res.StatusCode, url)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(body, out)
+}
+
+// GetBttvEmotes returns all global and channel BetterTTV emotes
+func GetBttvEmotes(providers models.EmoteProviders, channelId string) ([]models.ThirdPartyEmote, error) {
+	var global []models.BttvEmote
+	_, err := getJson(providers.BttvApi+"/3/cached/emotes/global", &global)
+	if err != nil {
+		return nil, err
+	}
+	channel := models.BttvChannelResponse{}
+	_, err = getJson(providers.BttvApi+"/3/cached/users/twitch/"+channelId, &channel)
+	if err != nil {
+		return nil, err
+	}
+	var emotes []models.ThirdPartyEmote
+	all := append(append(global, channel.ChannelEmotes...), channel.SharedEmotes...)
+	for _, emote := range all {
+		emotes = append(emotes, models.ThirdPartyEmote{
+			ID:         emote.ID,
+			Name:       emote.Code,
+			ImageUrl:   providers.BttvCdn + "/emote/" + emote.ID + "/2x",
+			ImageScale: 2,
+		})
+	}
+	return emotes, nil
+}
+
+// GetFfzEmotes returns all global and channel FrankerFaceZ emotes (through the BetterTTV cache)
+func GetFfzEmotes(providers models.EmoteProviders, channelId string) ([]models.ThirdPartyEmote, error) {
+	var global []models.FfzEmote
+	_, err := getJson(providers.BttvApi+"/3/cached/frankerfacez/emotes/global", &global)
+	if err != nil {
+		return nil, err
+	}
+	var channel []models.FfzEmote
+	_, err = getJson(providers.BttvApi+"/3/cached/frankerfacez/users/twitch/"+channelId, &channel)
+	if err != nil {
+		return nil, err
+	}
+	var emotes []models.ThirdPartyEmote
+	for _, emote := range append(global, channel...) {
+		scale := 2
+		url, ok := emote.Images["2x"]
+		if !ok || url == "" {
+			scale = 1
+			url = emote.Images["1x"]
+		}
+		if url == "" {
+			continue
+		}
+		emotes = append(emotes, models.ThirdPartyEmote{
+			ID:         strconv.Itoa(emote.ID),
+			Name:       emote.Code,
+			ImageUrl:   url,
+			ImageScale: scale,
+		})
+	}
+	return emotes, nil
+}
+
+// GetSevenTvEmotes returns all global and channel 7TV emotes
+func GetSevenTvEmotes(providers models.EmoteProviders, channelId string) ([]models.ThirdPartyEmote, error) {
+	global := models.SevenTvEmoteSet{}
+	_, err := getJson(providers.SevenTvApi+"/v3/emote-sets/global", &global)
+	if err != nil {
+		return nil, err
+	}
+	channel := models.SevenTvUserResponse{}
+	_, err = getJson(providers.SevenTvApi+"/v3/users/twitch/"+channelId, &channel)
+	if err != nil {
+		return nil, err
+	}
+	var emotes []models.ThirdPartyEmote
+	for _, emote := range append(global.Emotes, channel.EmoteSet.Emotes...) {
+		emotes = append(emotes, models.ThirdPartyEmote{
+			ID:         emote.ID,
+			Name:       emote.Name,
+			ImageUrl:   providers.SevenTvCdn + "/emote/" + emote.ID + "/2x.webp",
+			ImageScale: 2,
+		})
+	}
+	return emotes, nil
+}
@@ -0,0 +1,144 @@
+package twitch
+
+import (
+	"github.com/goldbattle/twitch_vods/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newEmoteServer pretends to be all of the emote providers, channel 123 has emotes, 404 has none and 500 fails
+func newEmoteServer() *httptest.Server {
+	responses := map[string]string{
+		"/3/cached/emotes/global":                     `[{"id":"b1","code":"bttvGlobal"}]`,
+		"/3/cached/users/twitch/123":                  `{"channelEmotes":[{"id":"b2","code":"bttvChannel"}],"sharedEmotes":[{"id":"b3","code":"bttvShared"}]}`,
+		"/3/cached/frankerfacez/emotes/global":        `[{"id":1,"code":"ffzBoth","images":{"1x":"https://ffz/1/1x","2x":"https://ffz/1/2x"}},{"id":2,"code":"ffzSmall","images":{"1x":"https://ffz/2/1x","2x":null}},{"id":3,"code":"ffzNone","images":{}}]`,
+		"/3/cached/frankerfacez/users/twitch/123":     `[{"id":4,"code":"ffzChannel","images":{"2x":"https://ffz/4/2x"}}]`,
+		"/v3/emote-sets/global":                       `{"emotes":[{"id":"s1","name":"7tvGlobal"}]}`,
+		"/v3/users/twitch/123":                        `{"emote_set":{"emotes":[{"id":"s2","name":"7tvChannel"}]}}`,
+		"/emoticons/v2/25/default/dark/2.0":           "kappa",
+		"/3/cached/frankerfacez/users/twitch/invalid": `{`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3/cached/users/twitch/500" || r.URL.Path == "/v3/users/twitch/500" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+}
+
+func testEmoteProviders(server *httptest.Server) models.EmoteProviders {
+	return DefaultEmoteProviders(models.EmoteProviders{
+		TwitchCdn:  server.URL + "/",
+		BttvApi:    server.URL,
+		BttvCdn:    "https://bttv-cdn/",
+		SevenTvApi: server.URL,
+		SevenTvCdn: "https://7tv-cdn",
+	})
+}
+
+func TestDefaultEmoteProviders(t *testing.T) {
+	providers := DefaultEmoteProviders(models.EmoteProviders{BttvApi: "http://localhost:1234/"})
+	if providers.BttvApi != "http://localhost:1234" {
+		t.Errorf("trailing slash was not removed: %s", providers.BttvApi)
+	}
+	if providers.TwitchCdn != "https://static-cdn.jtvnw.net" || providers.SevenTvApi != "https://7tv.io" {
+		t.Errorf("defaults were not set: %v", providers)
+	}
+	if url := TwitchEmoteUrl(providers, "25"); url != "https://static-cdn.jtvnw.net/emoticons/v2/25/default/dark/2.0" {
+		t.Errorf("got emote url %s", url)
+	}
+}
+
+func TestGetBttvEmotes(t *testing.T) {
+	server := newEmoteServer()
+	defer server.Close()
+	providers := testEmoteProviders(server)
+
+	// Channel emotes come after the global ones, and shared ones are the channel's too
+	emotes, err := GetBttvEmotes(providers, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.ThirdPartyEmote{
+		{ID: "b1", Name: "bttvGlobal", ImageUrl: "https://bttv-cdn/emote/b1/2x", ImageScale: 2},
+		{ID: "b2", Name: "bttvChannel", ImageUrl: "https://bttv-cdn/emote/b2/2x", ImageScale: 2},
+		{ID: "b3", Name: "bttvShared", ImageUrl: "https://bttv-cdn/emote/b3/2x", ImageScale: 2},
+	}
+	if !reflect.DeepEqual(emotes, want) {
+		t.Errorf("got %v, want %v", emotes, want)
+	}
+
+	// A channel which never used bttv still has the global emotes
+	emotes, err = GetBttvEmotes(providers, "404")
+	if err != nil || len(emotes) != 1 || emotes[0].Name != "bttvGlobal" {
+		t.Errorf("got %v %v for a channel without emotes", emotes, err)
+	}
+	if _, err = GetBttvEmotes(providers, "500"); err == nil {
+		t.Errorf("expected an error when the api fails")
+	}
+}
+
+func TestGetFfzEmotes(t *testing.T) {
+	server := newEmoteServer()
+	defer server.Close()
+	providers := testEmoteProviders(server)
+
+	// Emotes without a 2x image use the 1x one, and those without any image are skipped
+	emotes, err := GetFfzEmotes(providers, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.ThirdPartyEmote{
+		{ID: "1", Name: "ffzBoth", ImageUrl: "https://ffz/1/2x", ImageScale: 2},
+		{ID: "2", Name: "ffzSmall", ImageUrl: "https://ffz/2/1x", ImageScale: 1},
+		{ID: "4", Name: "ffzChannel", ImageUrl: "https://ffz/4/2x", ImageScale: 2},
+	}
+	if !reflect.DeepEqual(emotes, want) {
+		t.Errorf("got %v, want %v", emotes, want)
+	}
+	if _, err = GetFfzEmotes(providers, "invalid"); err == nil {
+		t.Errorf("expected an error for invalid json")
+	}
+}
+
+func TestGetSevenTvEmotes(t *testing.T) {
+	server := newEmoteServer()
+	defer server.Close()
+	providers := testEmoteProviders(server)
+
+	emotes, err := GetSevenTvEmotes(providers, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.ThirdPartyEmote{
+		{ID: "s1", Name: "7tvGlobal", ImageUrl: "https://7tv-cdn/emote/s1/2x.webp", ImageScale: 2},
+		{ID: "s2", Name: "7tvChannel", ImageUrl: "https://7tv-cdn/emote/s2/2x.webp", ImageScale: 2},
+	}
+	if !reflect.DeepEqual(emotes, want) {
+		t.Errorf("got %v, want %v", emotes, want)
+	}
+	if _, err = GetSevenTvEmotes(providers, "500"); err == nil {
+		t.Errorf("expected an error when the api fails")
+	}
+}
+
+func TestDownloadImage(t *testing.T) {
+	server := newEmoteServer()
+	defer server.Close()
+	providers := testEmoteProviders(server)
+	data, err := DownloadImage(TwitchEmoteUrl(providers, "25"))
+	if err != nil || string(data) != "kappa" {
+		t.Errorf("got %q %v", data, err)
+	}
+	if _, err = DownloadImage(TwitchEmoteUrl(providers, "missing")); err == nil {
+		t.Errorf("expected an error for a missing emote")
+	}
+}
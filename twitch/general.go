@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/nicklaw5/helix"
 	"log"
+	"time"
 )
 
 func GetUser(client *helix.Client, username string) (helix.User, error) {
@@ -22,6 +23,9 @@ func GetUser(client *helix.Client, username string) (helix.User, error) {
 
 }
 
+// ErrNoLiveStreams is returned when the user is not live
+var ErrNoLiveStreams = errors.New("no live streams")
+
 func GetLatestStream(client *helix.Client, usernameId string) (helix.Stream, error) {
 
 	// Get the streams for this user
@@ -41,7 +45,7 @@ func GetLatestStream(client *helix.Client, usernameId string) (helix.Stream, err
 		return helix.Stream{}, err
 	}
 	if len(respStreams.Data.Streams) < 1 {
-		return helix.Stream{}, errors.New("no live streams")
+		return helix.Stream{}, ErrNoLiveStreams
 	}
 	//for _, video := range respStreams.Data.Streams {
 	//	fmt.Printf("%s - %s - %s\n", video.StartedAt, video.ID, video.Title)
@@ -114,3 +118,86 @@ func GetLatestVods(client *helix.Client, usernameId string, count int) ([]helix.
 	return respVideos.Data.Videos, nil
 
 }
+
+func GetVodById(client *helix.Client, vodId string) (helix.Video, error) {
+
+	// Get this specific video
+	err := errors.New("startup")
+	respVideos := &helix.VideosResponse{}
+	for i := 1; i < 5; i++ {
+		respVideos, err = client.GetVideos(&helix.VideosParams{
+			IDs: []string{vodId},
+		})
+		if err == nil {
+			break
+		}
+		log.Printf("ERROR: vod api call failed %s (try %d)\n", err, i)
+	}
+	if err != nil {
+		return helix.Video{}, err
+	}
+	if len(respVideos.Data.Videos) < 1 {
+		return helix.Video{}, errors.New("no vod returned")
+	}
+	return respVideos.Data.Videos[0], nil
+
+}
+
+func GetAllVods(client *helix.Client, usernameId string, videoType string, after time.Time, before time.Time) ([]helix.Video, error) {
+
+	// Default to all types of videos
+	if videoType == "" {
+		videoType = "all"
+	}
+
+	// Walk every page of videos for this specific user
+	// NOTE: videos are returned newest first, so we can stop once we are older than our range
+	var vods []helix.Video
+	cursor := ""
+	for {
+		err := errors.New("startup")
+		respVideos := &helix.VideosResponse{}
+		for i := 1; i < 5; i++ {
+			respVideos, err = client.GetVideos(&helix.VideosParams{
+				UserID: usernameId,
+				First:  100,
+				Sort:   "time",
+				Type:   videoType,
+				After:  cursor,
+			})
+			if err == nil {
+				break
+			}
+			log.Printf("ERROR: vod api call failed %s (try %d)\n", err, i)
+		}
+		if err != nil {
+			return vods, err
+		}
+		if respVideos.ErrorMessage != "" {
+			return vods, errors.New(respVideos.ErrorMessage)
+		}
+
+		// Only keep videos inside of our range
+		reachedEnd := false
+		for _, video := range respVideos.Data.Videos {
+			tm, _ := time.Parse("2006-01-02T15:04:05Z", video.CreatedAt)
+			if !before.IsZero() && !tm.Before(before) {
+				continue
+			}
+			if !after.IsZero() && tm.Before(after) {
+				reachedEnd = true
+				break
+			}
+			vods = append(vods, video)
+		}
+
+		// Move to the next page if there is one
+		cursor = respVideos.Data.Pagination.Cursor
+		if reachedEnd || cursor == "" || len(respVideos.Data.Videos) < 1 {
+			break
+		}
+
+	}
+	return vods, nil
+
+}
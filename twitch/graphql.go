@@ -9,7 +9,7 @@ import (
 	"strconv"
 )
 
-func CallGraphQl(url string, jsonPayload map[string]string) ([]byte, error) {
+func CallGraphQl(url string, jsonPayload interface{}) ([]byte, error) {
 	jsonValue, _ := json.Marshal(jsonPayload)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
 	if err != nil {
@@ -1,6 +1,7 @@
 package twitch
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func GetVodFromStreamId(client *helix.Client, username string, usernameId string, config models.ConfigurationFile, stream helix.Stream) (helix.Video, error) {
@@ -71,6 +73,12 @@ func GetVodFromStreamId(client *helix.Client, username string, usernameId string
 }
 
 func TestIfStreamIsLiveM3U8(username string) error {
+	_, err := GetLiveMasterPlaylist(username)
+	return err
+}
+
+// GetLiveMasterPlaylist returns the variants of the stream which is currently live
+func GetLiveMasterPlaylist(username string) (*m3u8.MasterPlaylist, error) {
 
 	// Now lets try to get the video
 	// Query twitch to get our request signature for m3u8 files
@@ -86,14 +94,14 @@ func TestIfStreamIsLiveM3U8(username string) error {
 	}
 	body, err := CallGraphQl("https://gql.twitch.tv/gql", jsonPayload)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Convert to the api response
 	apiResponse := models.GraphQLStreamPlaybackAccessResponse{}
 	err = json.Unmarshal(body, &apiResponse)
 	if err != nil {
-		return errors.New("error decoding GQL api endpoint")
+		return nil, errors.New("error decoding GQL api endpoint")
 	}
 
 	// Call our api endpoint to get the playlist
@@ -104,31 +112,142 @@ func TestIfStreamIsLiveM3U8(username string) error {
 	baseUrl += "&player=twitchweb&type=any&allow_source=true&playlist_include_framerate=true"
 	res, err := http.Get(baseUrl)
 	if err != nil {
-		return errors.New("error requesting playlist file")
+		return nil, errors.New("error requesting playlist file")
 	}
 	defer res.Body.Close()
 
 	// Return if not success
+	// NOTE: usher has no playlist for channels which are not live
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNoLiveStreams
+	}
 	if res.StatusCode != 200 {
-		return fmt.Errorf("got status code %d instead of 200", res.StatusCode)
+		return nil, fmt.Errorf("got status code %d instead of 200", res.StatusCode)
 	}
 
 	// Parse the m3u8 playlist
 	playlist, listType, err := m3u8.DecodeFrom(res.Body, false)
 	if err != nil {
-		return errors.New("error decoding m3u8 live file")
+		return nil, errors.New("error decoding m3u8 live file")
 	}
 	if listType != m3u8.MASTER {
-		return errors.New("error not valid m3u8.MASTER file")
+		return nil, errors.New("error not valid m3u8.MASTER file")
 	}
 	masterPlaylist := playlist.(*m3u8.MasterPlaylist)
-	//log.Printf("LIVE: %s - found %d variants", username, len(masterPlaylist.Variants))
-	//for idx, variant := range masterPlaylist.Variants {
-	//	log.Printf("%d - %s - %s", idx, variant.Resolution, variant.URI)
-	//}
 	if len(masterPlaylist.Variants) < 1 {
-		return errors.New("no valid playlists for the stream found")
+		return nil, errors.New("no valid playlists for the stream found")
 	}
-	return nil
+	return masterPlaylist, nil
+
+}
+
+// ErrAccessTokenExpired is returned when the signed playlist urls of a live stream are no longer valid
+var ErrAccessTokenExpired = errors.New("access token has expired")
+
+// LiveMediaPlaylist is a live variant along with the twitch specific tags the m3u8 parser does not keep
+// Prefetch are the urls of the next segments, which twitch lists before they are finished for low latency players
+type LiveMediaPlaylist struct {
+	*m3u8.MediaPlaylist
+	Prefetch   []string
+	DateRanges []LiveDateRange
+}
+
+// LiveDateRange is an EXT-X-DATERANGE tag, twitch uses these to mark stitched ads (class twitch-stitched-ad)
+type LiveDateRange struct {
+	Id         string
+	Class      string
+	StartDate  time.Time
+	Duration   float64
+	Attributes map[string]string
+}
 
+// IsStitchedAd returns true if the date range is an ad twitch has put into the stream
+func (dateRange LiveDateRange) IsStitchedAd() bool {
+	return dateRange.Class == "twitch-stitched-ad" || strings.HasPrefix(dateRange.Id, "stitched-ad")
+}
+
+// Contains returns true if the time is within the date range
+func (dateRange LiveDateRange) Contains(tm time.Time) bool {
+	end := dateRange.StartDate.Add(time.Duration(dateRange.Duration * float64(time.Second)))
+	return !tm.Before(dateRange.StartDate) && tm.Before(end)
+}
+
+// parseDateRange parses the attribute list of an EXT-X-DATERANGE tag
+// NOTE: quoted values can contain commas, so we can not just split on them
+func parseDateRange(value string) LiveDateRange {
+	attributes := make(map[string]string)
+	for len(value) > 0 {
+		idx := strings.Index(value, "=")
+		if idx == -1 {
+			break
+		}
+		key := strings.TrimSpace(value[:idx])
+		value = value[idx+1:]
+		attribute := ""
+		if strings.HasPrefix(value, "\"") {
+			end := strings.Index(value[1:], "\"")
+			if end == -1 {
+				end = len(value) - 1
+			}
+			attribute = value[1 : end+1]
+			value = value[end+1:]
+			if len(value) > 0 {
+				value = value[1:]
+			}
+		} else {
+			end := strings.Index(value, ",")
+			if end == -1 {
+				end = len(value)
+			}
+			attribute = value[:end]
+			value = value[end:]
+		}
+		attributes[key] = attribute
+		value = strings.TrimPrefix(value, ",")
+	}
+	dateRange := LiveDateRange{Id: attributes["ID"], Class: attributes["CLASS"], Attributes: attributes}
+	dateRange.StartDate, _ = time.Parse(time.RFC3339Nano, attributes["START-DATE"])
+	if duration, ok := attributes["DURATION"]; ok {
+		dateRange.Duration, _ = strconv.ParseFloat(duration, 64)
+	} else {
+		dateRange.Duration, _ = strconv.ParseFloat(attributes["PLANNED-DURATION"], 64)
+	}
+	return dateRange
+}
+
+// GetLiveMediaPlaylist returns the current segments of a live variant
+func GetLiveMediaPlaylist(uri string) (*LiveMediaPlaylist, error) {
+	res, err := http.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusUnauthorized {
+		return nil, ErrAccessTokenExpired
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("got status code %d instead of 200", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	playlist, listType, err := m3u8.DecodeFrom(bytes.NewReader(body), false)
+	if err != nil {
+		return nil, errors.New("error decoding m3u8 media file")
+	}
+	if listType != m3u8.MEDIA {
+		return nil, errors.New("error not valid m3u8.MEDIA file")
+	}
+	live := &LiveMediaPlaylist{MediaPlaylist: playlist.(*m3u8.MediaPlaylist)}
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#EXT-X-TWITCH-PREFETCH:") {
+			live.Prefetch = append(live.Prefetch, strings.TrimPrefix(line, "#EXT-X-TWITCH-PREFETCH:"))
+		}
+		if strings.HasPrefix(line, "#EXT-X-DATERANGE:") {
+			live.DateRanges = append(live.DateRanges, parseDateRange(strings.TrimPrefix(line, "#EXT-X-DATERANGE:")))
+		}
+	}
+	return live, nil
 }
@@ -0,0 +1,136 @@
+package twitch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseDateRange(t *testing.T) {
+	start := time.Date(2023, 11, 14, 22, 13, 20, 500000000, time.UTC)
+	tests := []struct {
+		name     string
+		value    string
+		id       string
+		class    string
+		start    time.Time
+		duration float64
+		isAd     bool
+		attrs    map[string]string
+	}{
+		{
+			name:     "stitched ad",
+			value:    `ID="stitched-ad-1700000000-30",CLASS="twitch-stitched-ad",START-DATE="2023-11-14T22:13:20.500Z",DURATION=30.025,X-TV-TWITCH-AD-ROLL-TYPE="MIDROLL"`,
+			id:       "stitched-ad-1700000000-30",
+			class:    "twitch-stitched-ad",
+			start:    start,
+			duration: 30.025,
+			isAd:     true,
+			attrs:    map[string]string{"X-TV-TWITCH-AD-ROLL-TYPE": "MIDROLL"},
+		},
+		{
+			name:  "quoted commas",
+			value: `ID="source-1",CLASS="twitch-stream-source",START-DATE="2023-11-14T22:13:20.5Z",END-ON-NEXT=YES,X-TV-TWITCH-STREAM-SOURCE="live,backup"`,
+			id:    "source-1",
+			class: "twitch-stream-source",
+			start: start,
+			attrs: map[string]string{"X-TV-TWITCH-STREAM-SOURCE": "live,backup", "END-ON-NEXT": "YES"},
+		},
+		{
+			name:     "planned duration and id only ad",
+			value:    `ID="stitched-ad-2",START-DATE="2023-11-14T22:13:20.500Z",PLANNED-DURATION=15`,
+			id:       "stitched-ad-2",
+			start:    start,
+			duration: 15,
+			isAd:     true,
+		},
+		{
+			name:  "unterminated quote",
+			value: `ID="broken`,
+			id:    "broken",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, test := range tests {
+		dateRange := parseDateRange(test.value)
+		if dateRange.Id != test.id || dateRange.Class != test.class {
+			t.Errorf("%s: got id %q class %q, want %q %q", test.name, dateRange.Id, dateRange.Class, test.id, test.class)
+		}
+		if !dateRange.StartDate.Equal(test.start) {
+			t.Errorf("%s: got start %s, want %s", test.name, dateRange.StartDate, test.start)
+		}
+		if dateRange.Duration != test.duration {
+			t.Errorf("%s: got duration %.3f, want %.3f", test.name, dateRange.Duration, test.duration)
+		}
+		if dateRange.IsStitchedAd() != test.isAd {
+			t.Errorf("%s: got stitched ad %v, want %v", test.name, dateRange.IsStitchedAd(), test.isAd)
+		}
+		for key, value := range test.attrs {
+			if dateRange.Attributes[key] != value {
+				t.Errorf("%s: got %s=%q, want %q", test.name, key, dateRange.Attributes[key], value)
+			}
+		}
+	}
+}
+
+func TestDateRangeContains(t *testing.T) {
+	start := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	dateRange := LiveDateRange{StartDate: start, Duration: 30}
+	if dateRange.Contains(start.Add(-time.Second)) || !dateRange.Contains(start) {
+		t.Errorf("range should start at %s", start)
+	}
+	if !dateRange.Contains(start.Add(29*time.Second)) || dateRange.Contains(start.Add(30*time.Second)) {
+		t.Errorf("range should end 30 sec after %s", start)
+	}
+}
+
+func TestGetLiveMediaPlaylist(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-DATERANGE:ID="stitched-ad-1",CLASS="twitch-stitched-ad",START-DATE="2023-11-14T22:13:22.000Z",DURATION=4
+#EXT-X-PROGRAM-DATE-TIME:2023-11-14T22:13:20.000Z
+#EXTINF:2.000,live
+seg100.ts
+#EXT-X-PROGRAM-DATE-TIME:2023-11-14T22:13:22.000Z
+#EXTINF:2.000,live
+seg101.ts
+#EXT-X-TWITCH-PREFETCH:seg102.ts
+#EXT-X-TWITCH-PREFETCH:seg103.ts
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/live.m3u8":
+			_, _ = w.Write([]byte(playlist))
+		case "/expired.m3u8":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	live, err := GetLiveMediaPlaylist(server.URL + "/live.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live.Prefetch) != 2 || live.Prefetch[0] != "seg102.ts" {
+		t.Errorf("got prefetch %v", live.Prefetch)
+	}
+	if len(live.DateRanges) != 1 || !live.DateRanges[0].IsStitchedAd() {
+		t.Errorf("got date ranges %v", live.DateRanges)
+	}
+	if live.Segments[1] == nil || live.Segments[1].SeqId != 101 {
+		t.Errorf("got segments %v", live.Segments[:2])
+	}
+	if _, err = GetLiveMediaPlaylist(server.URL + "/expired.m3u8"); err != ErrAccessTokenExpired {
+		t.Errorf("got error %v, want %v", err, ErrAccessTokenExpired)
+	}
+	if _, err = GetLiveMediaPlaylist(server.URL + "/missing.m3u8"); err == nil {
+		t.Errorf("expected an error for a missing playlist")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"github.com/goldbattle/twitch_vods/algos"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/nicklaw5/helix"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+
+	// Load the config
+	if len(os.Args) < 2 {
+		log.Fatalf("CONFIG: please pass path to config as argument\n")
+	}
+	log.Printf("CONFIG: loading %s\n", os.Args[1])
+	config := helpers.LoadConfigFile(os.Args[1])
+
+	// Create the client
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:      config.TwitchClientId,
+		ClientSecret:  config.TwitchSecretId,
+		RateLimitFunc: twitch.RateLimitCallback,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Initialize methods responsible for refreshing oauth
+	waitForFirstAppAccessToken := make(chan struct{})
+	go twitch.InitAppAccessToken(client, waitForFirstAppAccessToken)
+	<-waitForFirstAppAccessToken
+
+	// Ensure we have channels
+	if len(config.ChannelsChatLog) < 1 {
+		log.Fatalf("CONFIG: please specify at least one chat channel to log\n")
+	}
+
+	// Get the user ids for this user
+	var usernames []string
+	var usernameIds []string
+	for _, username := range config.ChannelsChatLog {
+		user := helix.User{}
+		err := errors.New("startup")
+		for err != nil {
+			user, err = twitch.GetUser(client, username)
+			if err != nil {
+				log.Printf("ERROR: %s\n", err)
+			} else {
+				log.Printf("CLIENT: user %s -> %s\n", username, user.ID)
+			}
+		}
+		usernames = append(usernames, username)
+		usernameIds = append(usernameIds, user.ID)
+	}
+
+	// Create a listener for the sigterm to close our logger
+	stop := make(chan struct{})
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		close(stop)
+	}()
+
+	// Log all channels until we are stopped
+	hub := algos.NewChatHub()
+	logger := algos.NewChatLogger(client, hub, usernames, usernameIds, config)
+	logger.Run(stop)
+	hub.Close()
+
+}
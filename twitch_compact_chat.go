@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+
+	// Parse what journal we should compact
+	if len(os.Args) < 2 || !strings.HasSuffix(os.Args[1], ".jsonl") {
+		log.Fatalf("COMPACT: usage: <chat.jsonl> [--out <file>] [--name <streamer>] [--id <streamer id>] [--end <sec>]\n")
+	}
+	flags := flag.NewFlagSet("compact", flag.ExitOnError)
+	out := flags.String("out", strings.TrimSuffix(os.Args[1], ".jsonl")+".json", "output chat json")
+	name := flags.String("name", "", "streamer name (default is from the existing chat json)")
+	id := flags.Int("id", 0, "streamer id (default is from the existing chat json)")
+	end := flags.Float64("end", 0, "video length in seconds (default is the last comment)")
+	_ = flags.Parse(os.Args[2:])
+
+	// Keep what we already know from the chat json, if it exists
+	// NOTE: only the streamer and emotes are read, so the old comments are never all loaded into memory
+	streamer, emotes, err := helpers.LoadChatHeader(*out)
+	if err != nil {
+		streamer = models.Streamer{}
+		emotes = models.Emotes{}
+	}
+	if *name != "" {
+		streamer.Name = *name
+	}
+	if *id != 0 {
+		streamer.ID = *id
+	}
+
+	// Compact!
+	log.Printf("COMPACT: loading %s\n", os.Args[1])
+	count, err := helpers.CompactChatJournal(os.Args[1], *out, streamer, *end, emotes)
+	if err != nil {
+		log.Fatalf("COMPACT: error %s\n", err)
+	}
+	log.Printf("COMPACT: saved %d comments to %s\n", count, *out)
+
+}
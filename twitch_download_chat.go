@@ -64,6 +64,9 @@ func main() {
 		wg.Add(1)
 		go func(client *helix.Client, username string, usernameId string, config models.ConfigurationFile) {
 			defer wg.Done()
+			if config.Backfill {
+				algos.DownloadChatBackfill(client, username, usernameId, config)
+			}
 			for true {
 				algos.DownloadChatLatest(client, username, usernameId, config)
 				time.Sleep(time.Duration(config.QueryVodsMin) * time.Minute)
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"github.com/goldbattle/twitch_vods/algos"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/twitch"
+	"github.com/nicklaw5/helix"
+	"log"
+	"os"
+)
+
+func main() {
+
+	// Load the config
+	if len(os.Args) < 3 {
+		log.Fatalf("CONFIG: usage: <config> <vod id or url> --from <time> --to <time> [--copy]\n")
+	}
+	log.Printf("CONFIG: loading %s\n", os.Args[1])
+	config := helpers.LoadConfigFile(os.Args[1])
+
+	// Parse what part of the vod we want
+	flags := flag.NewFlagSet("clip", flag.ExitOnError)
+	fromStr := flags.String("from", "0", "start of the clip (seconds, hh:mm:ss or 1h2m3s)")
+	toStr := flags.String("to", "", "end of the clip (seconds, hh:mm:ss or 1h2m3s)")
+	copyCodec := flags.Bool("copy", false, "do not re-encode (faster, but cuts on keyframes)")
+	_ = flags.Parse(os.Args[3:])
+	vodId, err := helpers.ParseVodId(os.Args[2])
+	if err != nil {
+		log.Fatalf("CLIP: %s\n", err)
+	}
+	from, err := helpers.ParseTimestamp(*fromStr)
+	if err != nil {
+		log.Fatalf("CLIP: invalid --from %s\n", err)
+	}
+	to, err := helpers.ParseTimestamp(*toStr)
+	if err != nil {
+		log.Fatalf("CLIP: invalid --to %s\n", err)
+	}
+
+	// Create the client
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:      config.TwitchClientId,
+		ClientSecret:  config.TwitchSecretId,
+		RateLimitFunc: twitch.RateLimitCallback,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Initialize methods responsible for refreshing oauth
+	waitForFirstAppAccessToken := make(chan struct{})
+	go twitch.InitAppAccessToken(client, waitForFirstAppAccessToken)
+	<-waitForFirstAppAccessToken
+
+	// Get the vod so we know what channel it belongs to
+	vod, err := twitch.GetVodById(client, vodId)
+	if err != nil {
+		log.Fatalf("CLIP: %s - %s\n", vodId, err)
+	}
+	log.Printf("CLIP: vod %s from %s (%s to %s)\n", vod.ID, vod.UserLogin, helpers.FormatTimestamp(from), helpers.FormatTimestamp(to))
+	algos.DownloadVodClip(client, vod.UserLogin, config, vod, from, to, *copyCodec)
+
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+
+	// Load the chat file
+	if len(os.Args) < 2 {
+		log.Fatalf("EXPORT: usage: <chat.json> --format <srt|vtt|ass|txt|csv|html> [--out <file>] [--duration <sec>] [--lines <num>]\n")
+	}
+	log.Printf("EXPORT: loading %s\n", os.Args[1])
+	data, err := helpers.LoadChatFile(os.Args[1])
+	if err != nil {
+		log.Fatalf("EXPORT: error loading chat file %s\nEXPORT: %s\n", os.Args[1], err)
+	}
+
+	// Parse how we should export it
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "srt", "output format (srt, vtt, ass, txt, csv, html)")
+	out := flags.String("out", "", "output file (default is next to the chat file)")
+	duration := flags.Float64("duration", 6.0, "seconds each message is shown on screen")
+	lines := flags.Int("lines", 8, "max number of messages stacked on screen")
+	_ = flags.Parse(os.Args[2:])
+	*format = strings.ToLower(*format)
+	if *out == "" {
+		*out = strings.TrimSuffix(os.Args[1], filepath.Ext(os.Args[1])) + "." + *format
+	}
+
+	// Export!
+	switch *format {
+	case "srt", "vtt", "ass":
+		err = helpers.ExportChatToSubtitles(data, *out, *format, *duration, *lines)
+	case "txt":
+		err = helpers.ExportChatToText(data.Comments, *out)
+	case "csv":
+		err = helpers.ExportChatToCsv(data.Comments, *out)
+	case "html":
+		err = helpers.ExportChatToHtml(data, *out)
+	default:
+		log.Fatalf("EXPORT: unknown format %s\n", *format)
+	}
+	if err != nil {
+		log.Fatalf("EXPORT: error %s\n", err)
+	}
+	log.Printf("EXPORT: saved %d comments to %s\n", len(data.Comments), *out)
+
+}
@@ -49,6 +49,15 @@ func main() {
 	var usernames []string
 	var usernameIds []string
 	var shouldDownloadVideo []bool
+	var shouldRecordNative []bool
+	isNative := func(username string) bool {
+		for _, usernameNative := range config.ChannelsNative {
+			if strings.EqualFold(usernameNative, username) {
+				return true
+			}
+		}
+		return false
+	}
 	for _, username := range config.ChannelsLive {
 		user := helix.User{}
 		err := errors.New("startup")
@@ -63,6 +72,7 @@ func main() {
 		usernames = append(usernames, username)
 		usernameIds = append(usernameIds, user.ID)
 		shouldDownloadVideo = append(shouldDownloadVideo, true)
+		shouldRecordNative = append(shouldRecordNative, isNative(username))
 	}
 	for _, username := range config.ChannelsLiveChat {
 		// Check to see if we are already recording the video+chat
@@ -89,6 +99,7 @@ func main() {
 		usernames = append(usernames, username)
 		usernameIds = append(usernameIds, user.ID)
 		shouldDownloadVideo = append(shouldDownloadVideo, false)
+		shouldRecordNative = append(shouldRecordNative, isNative(username))
 	}
 
 	// Create a listener for the sigterm to close our threads
@@ -101,20 +112,27 @@ func main() {
 		gracefullSigterm = true
 	}()
 
+	// All recordings share the same irc connection for their chat
+	hub := algos.NewChatHub()
+	defer hub.Close()
+
 	// Start group
 	var wg sync.WaitGroup
 	for i := range usernameIds {
 		wg.Add(1)
-		go func(client *helix.Client, username string, usernameId string, downloadVideo bool, config models.ConfigurationFile) {
+		go func(client *helix.Client, hub *algos.ChatHub, username string, usernameId string, downloadVideo bool, native bool, config models.ConfigurationFile) {
 			defer wg.Done()
 			for !gracefullSigterm {
-				//algos.DownloadStreamLive(client, username, usernameId, config)
-				algos.DownloadStreamLiveStreamLink(client, username, usernameId, downloadVideo, config)
+				if native {
+					algos.DownloadStreamLive(client, hub, username, usernameId, downloadVideo, config)
+				} else {
+					algos.DownloadStreamLiveStreamLink(client, hub, username, usernameId, downloadVideo, config)
+				}
 				if !gracefullSigterm {
 					time.Sleep(time.Duration(config.QueryLiveMin) * time.Minute)
 				}
 			}
-		}(client, usernames[i], usernameIds[i], shouldDownloadVideo[i], config)
+		}(client, hub, usernames[i], usernameIds[i], shouldDownloadVideo[i], shouldRecordNative[i], config)
 	}
 
 	// Wait for all to complete
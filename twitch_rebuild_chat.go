@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/goldbattle/twitch_vods/algos"
+	"github.com/goldbattle/twitch_vods/helpers"
+	"github.com/goldbattle/twitch_vods/models"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+
+	// Parse what log we should rebuild
+	if len(os.Args) < 2 || !strings.HasSuffix(os.Args[1], "_irc.log") {
+		log.Fatalf("REBUILD: usage: <prefix>_irc.log [--start <time>] [--out <file>] [--name <streamer>] [--id <streamer id>] [--vod <vod id>]\n")
+	}
+	prefix := strings.TrimSuffix(os.Args[1], "_irc.log")
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	startStr := flags.String("start", "", "time of offset zero as RFC3339 or unix seconds (default is from the recorded chat)")
+	out := flags.String("out", prefix+"_chat.json", "output chat json")
+	name := flags.String("name", "", "streamer name (default is from the _info.json)")
+	id := flags.Int("id", 0, "streamer id (default is from the _info.json)")
+	vodId := flags.String("vod", "", "vod id of the comments (default is from the _info.json)")
+	_ = flags.Parse(os.Args[2:])
+
+	// Use what we know about the recording from its info file
+	info := models.StreamMetaData{}
+	file, err := ioutil.ReadFile(prefix + "_info.json")
+	if err == nil {
+		_ = json.Unmarshal(file, &info)
+	}
+	streamer := models.Streamer{Name: info.UserName}
+	streamer.ID, _ = strconv.Atoi(info.UserId)
+	if *name != "" {
+		streamer.Name = *name
+	}
+	if *id != 0 {
+		streamer.ID = *id
+	}
+	if *vodId == "" {
+		*vodId = info.Id
+	}
+
+	// Find when the recording started
+	// NOTE: newer recordings save the time of offset zero, for older ones we estimate it from the chat they saved
+	start := time.Time{}
+	if *startStr != "" {
+		if seconds, errParse := strconv.ParseFloat(*startStr, 64); errParse == nil {
+			start = time.Unix(0, int64(seconds*1e9))
+		} else if start, err = time.Parse(time.RFC3339, *startStr); err != nil {
+			log.Fatalf("REBUILD: invalid --start %s\n", err)
+		}
+	} else if info.ChatAlignment != nil {
+		start = info.ChatAlignment.ReferenceTime
+	} else if _, err = os.Stat(prefix + "_chat.jsonl"); err == nil {
+		start, _ = algos.EstimateChatStart(helpers.IterateChatJournal(prefix + "_chat.jsonl"))
+	} else if data, err := helpers.LoadChatFile(prefix + "_chat.json"); err == nil {
+		start, _ = algos.EstimateChatStart(helpers.IterateComments(data.Comments))
+	}
+	if start.IsZero() {
+		log.Printf("REBUILD: no start time found, using the first message\n")
+	} else {
+		log.Printf("REBUILD: offsets are from %s\n", start.UTC().Format(time.RFC3339Nano))
+	}
+
+	// Rebuild!
+	log.Printf("REBUILD: loading %s\n", os.Args[1])
+	// NOTE: cut ad breaks are only known relative to the recorded start, so they are ignored if it was given
+	adBreaks := info.AdBreaks
+	if *startStr != "" || info.ChatAlignment == nil {
+		adBreaks = nil
+	}
+	count, err := algos.RebuildChatFromIrcLog(os.Args[1], start, streamer, *vodId, *out, prefix+"_moderation.json", adBreaks)
+	if err != nil {
+		log.Fatalf("REBUILD: error %s\n", err)
+	}
+	log.Printf("REBUILD: saved %d comments to %s\n", count, *out)
+
+}